@@ -5,8 +5,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"9fans.net/acme-lsp/internal/lsp"
@@ -37,48 +40,177 @@ attempt to find the focused window ID by connecting to acmefocused
 
 List of sub-commands:
 
+	act [-p] [n]
+		List code actions (quick fixes, refactorings, etc.) available
+		for the selection and the diagnostics overlapping it in an
+		acme window named /LSP/CodeActions. Looking at (button 3) or
+		executing (button 2) an action applies it. If -p flag is given,
+		the numbered list of actions is printed to stdout instead. If
+		n is given, the n-th action is applied.
+
+	callers [-d depth]
+		List the functions calling the function under the cursor as
+		an indented tree, with the location of each call site. A
+		function calling it several times is listed once per call
+		site. The tree is expanded up to depth levels (default 1).
+		A function reached again through another path is expanded
+		once and marked "(already shown)" afterwards.
+
+	callees [-d depth]
+		List the functions called by the function under the cursor
+		as an indented tree, with the location of each definition.
+		The tree is expanded up to depth levels (default 1).
+		A function reached again through another path is expanded
+		once and marked "(already shown)" afterwards.
+
 	comp [-e] [-E]
 		Print candidate completions at the cursor position. If
 		-e (edit) flag is given and there is only one candidate,
 		the completion is applied instead of being printed. If
 		-E (Edit) flag is given, the first matching candidate is
 		applied, and all matches will be displayed in a dedicated
-		Acme window named /LSP/Completions.
+		Acme window named /LSP/Completions, followed by the
+		documentation of the applied candidate. Clicking a
+		candidate in that window with button 2 or 3 replaces the
+		applied one with it, and "Filter prefix" in its tag
+		narrows the list to the candidates fuzzily matching
+		prefix. With -E, L keeps running in the background to
+		serve the window until it is deleted. Applying a candidate also applies its
+		additional edits, such as adding the import of its
+		package. Snippets are expanded into plain text and the
+		first placeholder, if any, is selected so that it can be
+		typed over.
+
+	decl [-p]
+		Find where the symbol at the cursor position is declared
+		and send the location to the plumber. If -p flag is given,
+		the location is printed to stdout instead.
 
 	def [-p]
 		Find where the symbol at the cursor position is defined
 		and send the location to the plumber. If -p flag is given,
 		the location is printed to stdout instead.
 
-	fmt
-		Organize imports and format current window buffer.
+	diag [-w]
+		Print the diagnostics of the current window buffer last
+		published by the language servers, in a form that can be
+		plumbed. If -w flag is given, the diagnostics of all
+		documents are printed instead, without needing a window.
+
+	fix [-n] [kind ...]
+		Run the code actions of the given kinds, such as
+		source.fixAll (the default), source.addMissingImports or
+		quickfix, over the whole current window buffer and then
+		format it like fmt. The code actions applied are printed.
+		If -n flag is given, the changes are printed as a unified
+		diff instead of being made; code actions that execute a
+		command or create, rename or delete files are skipped.
+
+	fmt [line0[,line1] ...]
+		Organize imports and format current window buffer. If the
+		selection is not empty and the language server supports
+		range formatting, only the selection is formatted. If line
+		ranges are given, only those lines are formatted, using a
+		single request if the server can format several ranges
+		at once.
+
+	hl [-p]
+		Select the next occurrence of the symbol under the cursor
+		in the current window, wrapping around to the first one.
+		If -p flag is given, the addresses of all occurrences are
+		printed to stdout instead.
+
+	hints
+		Show the current window body annotated with inlay hints
+		(inferred types, parameter names, etc.) in an acme window
+		named /LSP/Hints/<file>. If the selection is not empty, only
+		hints within the selection are shown. The hints of each line
+		are appended to it after a tab, each preceded by its column
+		and enclosed in «», so that line and column addresses in
+		the annotated copy match the original file. The window is
+		updated, for the same selection, when the language server
+		asks for the hints to be refreshed.
 
 	hov
 		Show more information about the symbol under the cursor
-		("hover").
+		("hover"). Markdown is rendered as plain text, with the
+		targets of links listed as numbered footnotes at the end.
 
-	impls
+	impls [-p]
 		List implementation location(s) of the symbol under the cursor.
+		If there is only one implementation, its location is sent to
+		the plumber instead, unless -p flag is given.
+
+	lens [-p] [n]
+		List the code lenses (e.g. run test, go generate) of the
+		current file in an acme window named /LSP/Lens/<file>. The
+		list is updated when the language server asks for the lenses
+		to be refreshed. If -p flag is given, the numbered list of
+		lenses is printed to stdout instead. If n is given, the
+		command of the n-th lens is executed and its result printed.
+		Messages shown by the language server while it executes the
+		command appear in an acme window named /LSP/Messages.
+
+	nexterr
+		Select the range of the next diagnostic in the current
+		window, wrapping around to the first one.
+
+	preverr
+		Select the range of the previous diagnostic in the current
+		window, wrapping around to the last one.
 
 	refs
 		List locations where the symbol under the cursor is used
 		("references").
 
-	rn <newname>
-		Rename the symbol under the cursor to newname.
+	rn [-n] <newname>
+		Rename the symbol under the cursor to newname. If -n flag
+		is given, the changes are printed as a unified diff instead
+		of being applied, followed by the files that would be
+		created, renamed or deleted (e.g. when renaming a class).
+
+	rn -apply
+		Apply the changes printed by the last rn -n command, unless
+		any of the affected files changed since then. Affected files
+		that aren't open in acme are opened in new windows.
 
 	sig
 		Show signature help for the function, method, etc. under
-		the cursor.
+		the cursor. The active signature is shown first, with the
+		active parameter enclosed in «», followed by its
+		documentation and the documentation of its parameters.
+		Other overloads are listed, numbered, at the end.
+
+	supertypes [-d depth]
+		List the types the type under the cursor inherits from or
+		implements, recursively, as an indented tree of locations.
+		If -d flag is given, the tree is expanded only up to depth
+		levels. A type reached again through another path is
+		expanded once and marked "(already shown)" afterwards.
+
+	subtypes [-d depth]
+		List the types inheriting from or implementing the type
+		under the cursor, recursively, as an indented tree of
+		locations. If -d flag is given, the tree is expanded only
+		up to depth levels. A type reached again through another
+		path is expanded once and marked "(already shown)"
+		afterwards.
 
 	syms
-		List symbols in the current file.
+		List symbols in the current file, as an indented tree.
+		Each symbol is followed by its location, with the absolute
+		path of the file.
 
 	type [-p]
 		Find where the type of the symbol at the cursor position
 		is defined and send the location to the plumber. If -p
 		flag is given, the location is printed to stdout instead.
 
+	where
+		Print the chain of symbols enclosing the cursor position,
+		from the outermost to the innermost (e.g. Type > Method >
+		closure).
+
 	assist [comp|hov|sig]
 		A new window is created where completion (comp), hover
 		(hov), or signature help (sig) output is shown depending
@@ -99,12 +231,39 @@ List of sub-commands:
 		Remove given directories to the set of workspace directories.
 		Current working directory is removed if no directory is specified.
 
-	wss query
-		Print workspace symbols matching the query string.
-
-	exec command [args...]
+	wss [-k kinds] [-s server] [-l limit] query
+		Print workspace symbols matching the query string, best
+		matches first. Each line contains the kind of the symbol,
+		its name qualified by its container and its location.
+		The -k flag restricts the symbols to a comma-separated
+		list of kinds (e.g. function,struct), where func, type,
+		var and const stand for all the kinds of functions,
+		types, variables and constants. The -s flag queries only
+		the server with the given key in the configuration file.
+		The -l flag limits the number of symbols printed.
+
+	cmds
+		Print the commands supported by each running language
+		server, one per line preceded by the key of the server in
+		the configuration file. Servers are started when a file
+		they handle is opened, so servers that haven't been started
+		yet aren't listed.
+
+	exec [-s server] command [args...]
 		Execute a command against the language server, args must be valid
-		JSON of any type.
+		JSON of any type. The command is executed by the server with
+		the given key in the configuration file if the -s flag is
+		given, otherwise by the server handling the document in the
+		focused window if it supports the command, otherwise by any
+		server supporting it. The placeholders ${uri}, ${position}
+		and ${range} within args are replaced by the URI of the
+		document in the focused window and the position and range
+		of its current selection. A placeholder within a larger
+		JSON string, as in '"file ${uri}"', must have a string
+		value, i.e. ${uri}. For example, this toggles the
+		gc details annotations of gopls for the current package:
+
+			L exec gopls.gc_details '${uri}'
 `
 
 func usage() {
@@ -122,6 +281,8 @@ func main() {
 	if err != nil {
 		log.Fatalf("%v", err)
 	}
+	// Keep serving the windows created by the command, if any.
+	acmelsp.WaitWindows()
 }
 
 func run(cfg *config.Config, args []string) error {
@@ -202,16 +363,61 @@ func run(cfg *config.Config, args []string) error {
 		return fmt.Errorf("unknown assist command %q", args[0])
 	case "wss":
 		args = args[1:]
+		var (
+			kinds     []protocol.SymbolKind
+			serverKey string
+			limit     int
+		)
+		for len(args) > 1 && strings.HasPrefix(args[0], "-") {
+			switch args[0] {
+			case "-k":
+				kinds, err = acmelsp.ParseSymbolKinds(args[1])
+				if err != nil {
+					return err
+				}
+			case "-s":
+				serverKey = args[1]
+			case "-l":
+				limit, err = strconv.Atoi(args[1])
+				if err != nil || limit < 1 {
+					return fmt.Errorf("invalid limit %q", args[1])
+				}
+			default:
+				return fmt.Errorf("usage: wss [-k kinds] [-s server] [-l limit] query")
+			}
+			args = args[2:]
+		}
 		if len(args) == 0 {
 			return fmt.Errorf("missing query")
 		}
-		return acmelsp.Symbol(server, args[0])
+		return acmelsp.WorkspaceSymbol(ctx, os.Stdout, server, args[0], serverKey, kinds, limit)
+	case "cmds":
+		return acmelsp.Commands(ctx, os.Stdout, server)
+	case "diag":
+		if len(args) > 1 && args[1] == "-w" {
+			return acmelsp.PrintDiagnostics(ctx, os.Stdout, server)
+		}
 	case "exec":
 		args = args[1:]
+		var serverKey string
+		if len(args) > 1 && args[0] == "-s" {
+			serverKey = args[1]
+			args = args[2:]
+		}
 		if len(args) < 1 {
-			return fmt.Errorf("usage: exec command arguments...")
+			return fmt.Errorf("usage: exec [-s server] command arguments...")
+		}
+		// The focused window is optional: it's only needed to route
+		// the command by document and to substitute placeholders.
+		var win text.AddressableFile
+		if w, err := acmelsp.OpenFocusedWin(cfg.Headless); err == nil {
+			defer w.CloseFiles()
+			rc := acmelsp.NewRemoteCmd(server, w, &text.HeadlessMenu{})
+			if err := rc.SyncDocument(ctx); err == nil {
+				win = w
+			}
 		}
-		return acmelsp.Execute(server, "", args[0], args[1:])
+		return acmelsp.Execute(ctx, os.Stdout, server, win, serverKey, args[0], args[1:])
 	}
 
 	win, err := acmelsp.OpenFocusedWin(cfg.Headless)
@@ -236,6 +442,23 @@ func run(cfg *config.Config, args []string) error {
 	}
 
 	switch args[0] {
+	case "act":
+		args = args[1:]
+		print, n := false, 0
+		if len(args) > 0 {
+			if args[0] == "-p" {
+				print = true
+			} else if n, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("invalid code action number %q", args[0])
+			}
+		}
+		return rc.CodeAction(ctx, print, n)
+	case "callers", "callees":
+		depth, err := parseDepth(args[1:], 1)
+		if err != nil {
+			return err
+		}
+		return rc.CallHierarchy(ctx, args[0] == "callers", depth)
 	case "comp":
 		args = args[1:]
 
@@ -250,34 +473,127 @@ func run(cfg *config.Config, args []string) error {
 		}
 
 		return rc.Completion(ctx, kind)
+	case "decl":
+		args = args[1:]
+		return rc.Declaration(ctx, len(args) > 0 && args[0] == "-p")
 	case "def":
 		args = args[1:]
 		return rc.Definition(ctx, len(args) > 0 && args[0] == "-p")
+	case "diag":
+		return rc.Diagnostics(ctx)
+	case "fix":
+		args = args[1:]
+		preview := len(args) > 0 && args[0] == "-n"
+		if preview {
+			args = args[1:]
+		}
+		kinds := []protocol.CodeActionKind{protocol.SourceFixAll}
+		if len(args) > 0 {
+			kinds = nil
+			for _, arg := range args {
+				kinds = append(kinds, protocol.CodeActionKind(arg))
+			}
+		}
+		return rc.Fix(ctx, kinds, preview)
 	case "fmt":
-		return rc.OrganizeImportsAndFormat(ctx)
+		var ranges []protocol.Range
+		for _, arg := range args[1:] {
+			rng, err := parseLineRange(arg)
+			if err != nil {
+				return err
+			}
+			ranges = append(ranges, rng)
+		}
+		return rc.Format(ctx, ranges)
+	case "hl":
+		args = args[1:]
+		return rc.DocumentHighlight(ctx, len(args) > 0 && args[0] == "-p")
+	case "hints":
+		return rc.InlayHints(ctx)
 	case "hov":
 		return rc.Hover(ctx)
 	case "impls":
-		return rc.Implementation(ctx, true)
+		args = args[1:]
+		return rc.Implementation(ctx, len(args) > 0 && args[0] == "-p")
+	case "lens":
+		args = args[1:]
+		print, n := false, 0
+		if len(args) > 0 {
+			if args[0] == "-p" {
+				print = true
+			} else if n, err = strconv.Atoi(args[0]); err != nil {
+				return fmt.Errorf("invalid code lens number %q", args[0])
+			}
+		}
+		return rc.CodeLens(ctx, print, n)
+	case "nexterr", "preverr":
+		return rc.NextDiagnostic(ctx, args[0] == "preverr")
 	case "refs":
 		return rc.References(ctx)
 	case "rn":
 		args = args[1:]
-		if len(args) < 1 {
+		switch {
+		case len(args) == 1 && args[0] == "-apply":
+			return rc.ApplyRename(ctx)
+		case len(args) == 2 && args[0] == "-n":
+			return rc.RenamePreview(ctx, args[1])
+		case len(args) != 1:
 			usage()
 		}
 		return rc.Rename(ctx, args[0])
 	case "sig":
 		return rc.SignatureHelp(ctx)
+	case "supertypes", "subtypes":
+		depth, err := parseDepth(args[1:], math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		return rc.TypeHierarchy(ctx, args[0] == "supertypes", depth)
 	case "syms":
 		return rc.DocumentSymbol(ctx)
 	case "type":
 		args = args[1:]
 		return rc.TypeDefinition(ctx, len(args) > 0 && args[0] == "-p")
+	case "where":
+		return rc.EnclosingSymbols(ctx)
 	}
 	return fmt.Errorf("unknown command %q", args[0])
 }
 
+// parseLineRange parses a range of 1-based lines, such as "10" or
+// "10,20", into a protocol range covering those lines entirely.
+func parseLineRange(s string) (protocol.Range, error) {
+	first, last, found := strings.Cut(s, ",")
+	if !found {
+		last = first
+	}
+	l0, err0 := strconv.Atoi(first)
+	l1, err1 := strconv.Atoi(last)
+	if err0 != nil || err1 != nil || l0 < 1 || l1 < l0 {
+		return protocol.Range{}, fmt.Errorf("invalid line range %q", s)
+	}
+	return protocol.Range{
+		Start: protocol.Position{Line: uint32(l0 - 1)},
+		End:   protocol.Position{Line: uint32(l1)},
+	}, nil
+}
+
+// parseDepth parses the optional "-d depth" arguments of a hierarchy
+// command. The depth defaults to def.
+func parseDepth(args []string, def int) (int, error) {
+	if len(args) == 0 {
+		return def, nil
+	}
+	if len(args) != 2 || args[0] != "-d" {
+		return 0, fmt.Errorf("usage: [-d depth]")
+	}
+	depth, err := strconv.Atoi(args[1])
+	if err != nil || depth < 1 {
+		return 0, fmt.Errorf("invalid depth %q", args[1])
+	}
+	return depth, nil
+}
+
 func dirsOrCurrentDir(dirs []string) ([]protocol.WorkspaceFolder, error) {
 	if len(dirs) == 0 {
 		d, err := os.Getwd()
@@ -64,6 +64,18 @@ func (w *Win) CurrentAddr() (q0, q1 int, err error) {
 	return w.ReadAddr()
 }
 
+// SetCurrentAddr selects the text in rune range [q0, q1) and
+// makes sure it's visible.
+func (w *Win) SetCurrentAddr(q0, q1 int) error {
+	if err := w.Addr("#%d,#%d", q0, q1); err != nil {
+		return fmt.Errorf("write addr: %v", err)
+	}
+	if err := w.Ctl("dot=addr"); err != nil {
+		return fmt.Errorf("setting dot=addr: %v", err)
+	}
+	return w.Ctl("show")
+}
+
 func (w *Win) FileReadWriter(filename string) io.ReadWriter {
 	return &winReadWriter{
 		w:    w.Win,
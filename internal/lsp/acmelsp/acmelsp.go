@@ -125,46 +125,38 @@ type FormatServer interface {
 	Formatting(context.Context, *protocol.DocumentFormattingParams) ([]protocol.TextEdit, error)
 	CodeAction(context.Context, *protocol.CodeActionParams) ([]protocol.CodeAction, error)
 	ExecuteCommandOnDocument(context.Context, *proxy.ExecuteCommandOnDocumentParams) (interface{}, error)
+	fileNotifier
 }
 
-// CodeActionAndFormat runs the given code actions and then formats the file f.
-func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol.TextDocumentIdentifier, f text.File, menu text.Menu, actions []protocol.CodeActionKind) error {
+// CodeActionAndFormat runs the code actions of the given kinds over the
+// whole file f and then formats it. It returns the code actions applied.
+func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol.TextDocumentIdentifier, f text.File, menu text.Menu, kinds []protocol.CodeActionKind) ([]protocol.CodeAction, error) {
 	initres, err := server.InitializeResult(ctx, doc)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	actions = lsp.CompatibleCodeActions(&initres.Capabilities, actions)
-	if len(actions) > 0 {
-		actions, err := server.CodeAction(ctx, &protocol.CodeActionParams{
+	var actions []protocol.CodeAction
+	kinds = lsp.CompatibleCodeActions(&initres.Capabilities, kinds)
+	if len(kinds) > 0 {
+		rng, err := fileRange(f)
+		if err != nil {
+			return nil, err
+		}
+		actions, err = server.CodeAction(ctx, &protocol.CodeActionParams{
 			TextDocument: *doc,
-			Range:        protocol.Range{},
+			Range:        rng,
 			Context: protocol.CodeActionContext{
 				Diagnostics: []protocol.Diagnostic{},
-				Only:        actions,
+				Only:        kinds,
 			},
 		})
 		if err != nil {
-			return err
+			return nil, err
 		}
-		for _, a := range actions {
-			if a.Edit != nil {
-				err := editWorkspace(a.Edit, menu)
-				if err != nil {
-					return err
-				}
-			}
-			if a.Command != nil {
-				_, err := server.ExecuteCommandOnDocument(ctx, &proxy.ExecuteCommandOnDocumentParams{
-					TextDocument: *doc,
-					ExecuteCommandParams: protocol.ExecuteCommandParams{
-						Command:   a.Command.Command,
-						Arguments: a.Command.Arguments,
-					},
-				})
-				if err != nil {
-					return err
-				}
+		for i := range actions {
+			if err := applyCodeAction(ctx, server, doc, &actions[i], menu); err != nil {
+				return nil, err
 			}
 		}
 		if len(actions) > 0 {
@@ -173,17 +165,17 @@ func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol
 			// TODO(fhs): Skip if our file didn't have import changes.
 			rd, err := f.Reader()
 			if err != nil {
-				return err
+				return nil, err
 			}
 			b, err := io.ReadAll(rd)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if err = server.SyncDocument(ctx, &proxy.SyncDocumentParams{
 				TextDocument: *doc,
 				Content:      string(b),
 			}); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
@@ -191,10 +183,57 @@ func CodeActionAndFormat(ctx context.Context, server FormatServer, doc *protocol
 		TextDocument: *doc,
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err := text.Edit(f, edits); err != nil {
-		return fmt.Errorf("failed to apply edits: %v", err)
+		return nil, fmt.Errorf("failed to apply edits: %v", err)
+	}
+	return actions, nil
+}
+
+// fileRange returns the range covering the whole file f.
+func fileRange(f text.File) (protocol.Range, error) {
+	body, err := readBody(f)
+	if err != nil {
+		return protocol.Range{}, err
+	}
+	off, err := text.GetNewlineOffsets(strings.NewReader(string(body)))
+	if err != nil {
+		return protocol.Range{}, err
+	}
+	line, col := off.OffsetToLine(len(body))
+	return protocol.Range{
+		End: protocol.Position{
+			Line:      uint32(line),
+			Character: uint32(col),
+		},
+	}, nil
+}
+
+type commandExecutor interface {
+	ExecuteCommandOnDocument(context.Context, *proxy.ExecuteCommandOnDocumentParams) (interface{}, error)
+	fileNotifier
+}
+
+// applyCodeAction applies the workspace edit of code action a and then
+// executes its command, if any, on the server that owns document doc.
+func applyCodeAction(ctx context.Context, server commandExecutor, doc *protocol.TextDocumentIdentifier, a *protocol.CodeAction, menu text.Menu) error {
+	if a.Edit != nil {
+		if err := editWorkspace(ctx, a.Edit, menu, server); err != nil {
+			return err
+		}
+	}
+	if a.Command != nil {
+		_, err := server.ExecuteCommandOnDocument(ctx, &proxy.ExecuteCommandOnDocumentParams{
+			TextDocument: *doc,
+			ExecuteCommandParams: protocol.ExecuteCommandParams{
+				Command:   a.Command.Command,
+				Arguments: a.Command.Arguments,
+			},
+		})
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -215,10 +254,10 @@ func filterUnsupportedTextEdits(edits []protocol.Or_TextDocumentEdit_edits_Elem)
 	return
 }
 
-func editWorkspace(we *protocol.WorkspaceEdit, menu text.Menu) error {
-	if we == nil {
-		return nil // no changes to apply
-	}
+// workspaceTextEdits returns the text edits of we by document. The
+// versioned document edits of we, if any, are converted to non-versioned
+// edits when we has no non-versioned edits. We is not modified.
+func workspaceTextEdits(we *protocol.WorkspaceEdit) (map[protocol.DocumentURI][]protocol.TextEdit, error) {
 	if we.Changes == nil && we.DocumentChanges != nil {
 		// gopls version >= 0.3.1 sends versioned document edits
 		// for organizeImports code action even when we don't
@@ -228,27 +267,49 @@ func editWorkspace(we *protocol.WorkspaceEdit, menu text.Menu) error {
 			if tde := dc.TextDocumentEdit; tde != nil {
 				edits, filtered := filterUnsupportedTextEdits(tde.Edits)
 				if filtered {
-					return fmt.Errorf("unsupported text edit type (e.g. snippet)")
+					return nil, fmt.Errorf("unsupported text edit type (e.g. snippet)")
 				}
 				changes[tde.TextDocument.TextDocumentIdentifier.URI] = edits
 			}
 		}
-		we.Changes = changes
+		return changes, nil
 	}
-	if we.Changes == nil {
+	return we.Changes, nil
+}
+
+// editWorkspace applies the workspace edit we and notifies server of
+// the files created, renamed or deleted by it.
+func editWorkspace(ctx context.Context, we *protocol.WorkspaceEdit, menu text.Menu, server fileNotifier) error {
+	if we == nil {
+		return nil // no changes to apply
+	}
+	if hasResourceOperations(we) {
+		return applyDocumentChanges(ctx, we.DocumentChanges, menu, server)
+	}
+	changes, err := workspaceTextEdits(we)
+	if err != nil {
+		return err
+	}
+	if changes == nil {
 		return nil // no changes to apply
 	}
 
-	for uri, edits := range we.Changes {
-		fname := text.ToPath(uri)
-		w, err := menu.Open(fname)
-		if err != nil {
-			return fmt.Errorf("failed to open window %v: %v", fname, err)
-		}
-		if err := text.Edit(w, edits); err != nil {
-			return fmt.Errorf("failed to apply edits to window %v: %v", fname, err)
+	for uri, edits := range changes {
+		if err := editFile(menu, text.ToPath(uri), edits); err != nil {
+			return err
 		}
-		w.CloseFiles()
+	}
+	return nil
+}
+
+func editFile(menu text.Menu, fname string, edits []protocol.TextEdit) error {
+	w, err := menu.Open(fname)
+	if err != nil {
+		return fmt.Errorf("failed to open window %v: %v", fname, err)
+	}
+	defer w.CloseFiles()
+	if err := text.Edit(w, edits); err != nil {
+		return fmt.Errorf("failed to apply edits to window %v: %v", fname, err)
 	}
 	return nil
 }
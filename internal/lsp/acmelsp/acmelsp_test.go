@@ -1,15 +1,25 @@
 package acmelsp
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"9fans.net/acme-lsp/internal/lsp"
 	"9fans.net/acme-lsp/internal/lsp/acmelsp/config"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
 	"9fans.net/go/plumb"
 	"9fans.net/internal/go-lsp/lsp/protocol"
 	"github.com/google/go-cmp/cmp"
@@ -159,3 +169,1410 @@ func TestParseFlagSet(t *testing.T) {
 		})
 	}
 }
+
+func TestAnnotateInlayHints(t *testing.T) {
+	hint := func(line, col uint32, label string, left, right bool) protocol.InlayHint {
+		return protocol.InlayHint{
+			Position:     protocol.Position{Line: line, Character: col},
+			Label:        []protocol.InlayHintLabelPart{{Value: label}},
+			PaddingLeft:  left,
+			PaddingRight: right,
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		body  string
+		hints []protocol.InlayHint
+		want  string
+	}{
+		{"NoHints", "x := f(1)\n", nil, "x := f(1)\n"},
+		{
+			"TypeAndParameter",
+			"x := f(1)\ny := 2\n",
+			[]protocol.InlayHint{
+				hint(0, 7, "n:", false, true),
+				hint(0, 1, "int", true, false),
+			},
+			"x := f(1)\t2«int» 8«n:»\ny := 2\n",
+		},
+		{"Unicode", "s := \"世界\"\n", []protocol.InlayHint{hint(0, 9, ";", false, false)}, "s := \"世界\"\t10«;»\n"},
+		{"SurrogatePair", "s := \"😀\"\nt\n", []protocol.InlayHint{hint(0, 9, ";", false, false)}, "s := \"😀\"\t9«;»\nt\n"},
+		{"PastEndOfLine", "a\nb", []protocol.InlayHint{hint(1, 10, "!", false, false)}, "a\nb\t2«!»"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := annotateInlayHints(tc.body, tc.hints)
+			if got != tc.want {
+				t.Errorf("annotateInlayHints returned %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAnnotateInlayHintsAddresses(t *testing.T) {
+	body := "x := f(1, 2)\ng(😀, y)\n"
+	hints := []protocol.InlayHint{
+		{Position: protocol.Position{Line: 0, Character: 1}, Label: []protocol.InlayHintLabelPart{{Value: "int"}}},
+		{Position: protocol.Position{Line: 0, Character: 7}, Label: []protocol.InlayHintLabelPart{{Value: "a:"}}},
+		{Position: protocol.Position{Line: 1, Character: 5}, Label: []protocol.InlayHintLabelPart{{Value: "b:"}}},
+	}
+	got := strings.Split(annotateInlayHints(body, hints), "\n")
+	want := strings.Split(body, "\n")
+	if len(got) != len(want) {
+		t.Fatalf("annotated body has %v lines; want %v", len(got), len(want))
+	}
+	for i := range want {
+		// Every character, including those after a hint, is at the
+		// same line and column as in body.
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("annotated line %q doesn't start with %q", got[i], want[i])
+		}
+	}
+	// The hint at UTF-16 offset 5, after the surrogate pair, is at
+	// column 5, the blank before y.
+	if !strings.HasSuffix(got[1], "\t5«b:»") {
+		t.Errorf("annotated line %q doesn't end with the hint at column 5", got[1])
+	}
+}
+
+type recordingNotifier struct {
+	created []protocol.FileCreate
+	renamed []protocol.FileRename
+	deleted []protocol.FileDelete
+}
+
+func (n *recordingNotifier) DidCreateFiles(_ context.Context, params *protocol.CreateFilesParams) error {
+	n.created = append(n.created, params.Files...)
+	return nil
+}
+
+func (n *recordingNotifier) DidRenameFiles(_ context.Context, params *protocol.RenameFilesParams) error {
+	n.renamed = append(n.renamed, params.Files...)
+	return nil
+}
+
+func (n *recordingNotifier) DidDeleteFiles(_ context.Context, params *protocol.DeleteFilesParams) error {
+	n.deleted = append(n.deleted, params.Files...)
+	return nil
+}
+
+func TestEditWorkspaceResourceOperations(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	uri := func(name string) protocol.DocumentURI { return text.ToURI(path(name)) }
+	for name, body := range map[string]string{
+		"main.rs": "mod util;\n",
+		"old.rs":  "fn old() {}\n",
+		"dead.rs": "",
+	} {
+		if err := os.WriteFile(path(name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	textEdit := func(name string, line, col uint32, newText string) protocol.DocumentChange {
+		pos := protocol.Position{Line: line, Character: col}
+		return protocol.DocumentChange{
+			TextDocumentEdit: &protocol.TextDocumentEdit{
+				TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri(name)},
+				},
+				Edits: []protocol.Or_TextDocumentEdit_edits_Elem{
+					{Value: protocol.TextEdit{Range: protocol.Range{Start: pos, End: pos}, NewText: newText}},
+				},
+			},
+		}
+	}
+	we := &protocol.WorkspaceEdit{
+		DocumentChanges: []protocol.DocumentChange{
+			{CreateFile: &protocol.CreateFile{Kind: "create", URI: uri("util/mod.rs")}},
+			textEdit("util/mod.rs", 0, 0, "pub fn f() {}\n"),
+			{RenameFile: &protocol.RenameFile{Kind: "rename", OldURI: uri("old.rs"), NewURI: uri("util/new.rs")}},
+			textEdit("util/new.rs", 0, 3, "new_"),
+			{DeleteFile: &protocol.DeleteFile{Kind: "delete", URI: uri("dead.rs")}},
+			{DeleteFile: &protocol.DeleteFile{
+				Kind:    "delete",
+				URI:     uri("missing.rs"),
+				Options: &protocol.DeleteFileOptions{IgnoreIfNotExists: true},
+			}},
+		},
+	}
+	var n recordingNotifier
+	if err := editWorkspace(context.Background(), we, &text.HeadlessMenu{}, &n); err != nil {
+		t.Fatalf("editWorkspace failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"util/mod.rs": "pub fn f() {}\n",
+		"util/new.rs": "fn new_old() {}\n",
+	} {
+		b, err := os.ReadFile(path(name))
+		if err != nil {
+			t.Errorf("%v", err)
+			continue
+		}
+		if string(b) != want {
+			t.Errorf("%v contains %q; want %q", name, b, want)
+		}
+	}
+	for _, name := range []string{"old.rs", "dead.rs"} {
+		if _, err := os.Stat(path(name)); !os.IsNotExist(err) {
+			t.Errorf("%v exists; want it removed", name)
+		}
+	}
+	want := recordingNotifier{
+		created: []protocol.FileCreate{{URI: string(uri("util/mod.rs"))}},
+		renamed: []protocol.FileRename{{OldURI: string(uri("old.rs")), NewURI: string(uri("util/new.rs"))}},
+		deleted: []protocol.FileDelete{{URI: string(uri("dead.rs"))}},
+	}
+	if diff := cmp.Diff(want, n, cmp.AllowUnexported(recordingNotifier{})); diff != "" {
+		t.Errorf("notifications mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestWorkspaceTextEdits(t *testing.T) {
+	uri := text.ToURI("/a.go")
+	edit := protocol.TextEdit{NewText: "x"}
+	we := &protocol.WorkspaceEdit{
+		DocumentChanges: []protocol.DocumentChange{{
+			TextDocumentEdit: &protocol.TextDocumentEdit{
+				TextDocument: protocol.OptionalVersionedTextDocumentIdentifier{
+					TextDocumentIdentifier: protocol.TextDocumentIdentifier{URI: uri},
+				},
+				Edits: []protocol.Or_TextDocumentEdit_edits_Elem{{Value: edit}},
+			},
+		}},
+	}
+	changes, err := workspaceTextEdits(we)
+	if err != nil {
+		t.Fatalf("workspaceTextEdits failed: %v", err)
+	}
+	want := map[protocol.DocumentURI][]protocol.TextEdit{uri: {edit}}
+	if diff := cmp.Diff(want, changes); diff != "" {
+		t.Errorf("text edits mismatch (-want +got):\n%s", diff)
+	}
+	if we.Changes != nil {
+		t.Errorf("workspace edit modified: Changes is %v", we.Changes)
+	}
+}
+
+// closedMenu is a text.Menu with no open files.
+type closedMenu struct {
+	text.HeadlessMenu
+}
+
+func (m *closedMenu) Open(filename string) (text.AddressableFile, error) {
+	return nil, fmt.Errorf("%v: not open", filename)
+}
+
+func TestReadFileBody(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "a.go")
+	if err := os.WriteFile(fname, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := readFileBody(&closedMenu{}, fname)
+	if err != nil {
+		t.Fatalf("readFileBody failed: %v", err)
+	}
+	if got, want := string(b), "package a\n"; got != want {
+		t.Errorf("body is %q; want %q", got, want)
+	}
+	if _, err := readFileBody(&closedMenu{}, fname+".missing"); err == nil {
+		t.Errorf("readFileBody succeeded for missing file")
+	}
+}
+
+func TestCompletionEdits(t *testing.T) {
+	snippet := protocol.SnippetTextFormat
+	rng := func(l0, c0, l1, c1 uint32) protocol.Range {
+		return protocol.Range{
+			Start: protocol.Position{Line: l0, Character: c0},
+			End:   protocol.Position{Line: l1, Character: c1},
+		}
+	}
+	body := "x := 1\nfmt.Pr\n"
+	for _, tc := range []struct {
+		name   string
+		item   protocol.CompletionItem
+		edits  []protocol.TextEdit
+		q0, q1 int
+	}{
+		{
+			"TextEdit",
+			protocol.CompletionItem{
+				Label:    "Println",
+				TextEdit: &protocol.Or_CompletionItem_textEdit{Value: protocol.TextEdit{Range: rng(1, 4, 1, 6), NewText: "Println"}},
+			},
+			[]protocol.TextEdit{{Range: rng(1, 4, 1, 6), NewText: "Println"}},
+			18, 18,
+		},
+		{
+			"InsertReplaceEdit",
+			protocol.CompletionItem{
+				Label: "Println",
+				TextEdit: &protocol.Or_CompletionItem_textEdit{Value: protocol.InsertReplaceEdit{
+					NewText: "Println",
+					Insert:  rng(1, 4, 1, 6),
+					Replace: rng(1, 4, 1, 7),
+				}},
+			},
+			[]protocol.TextEdit{{Range: rng(1, 4, 1, 6), NewText: "Println"}},
+			18, 18,
+		},
+		{
+			"InsertText",
+			protocol.CompletionItem{Label: "Println", InsertText: "Printf"},
+			[]protocol.TextEdit{{Range: rng(1, 4, 1, 6), NewText: "Printf"}},
+			17, 17,
+		},
+		{
+			"Label",
+			protocol.CompletionItem{Label: "Println"},
+			[]protocol.TextEdit{{Range: rng(1, 4, 1, 6), NewText: "Println"}},
+			18, 18,
+		},
+		{
+			"Snippet",
+			protocol.CompletionItem{
+				Label:            "Println",
+				InsertText:       "Println(${1:a ...any})$0",
+				InsertTextFormat: &snippet,
+			},
+			[]protocol.TextEdit{{Range: rng(1, 4, 1, 6), NewText: "Println(a ...any)"}},
+			19, 27,
+		},
+		{
+			"AdditionalTextEdits",
+			protocol.CompletionItem{
+				Label: "Println",
+				AdditionalTextEdits: []protocol.TextEdit{
+					{Range: rng(0, 0, 0, 0), NewText: "import \"fmt\"\n\n"},
+				},
+			},
+			[]protocol.TextEdit{
+				{Range: rng(1, 4, 1, 6), NewText: "Println"},
+				{Range: rng(0, 0, 0, 0), NewText: "import \"fmt\"\n\n"},
+			},
+			32, 32,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			edits, q0, q1 := completionEdits(&tc.item, []rune(body), 13)
+			if !reflect.DeepEqual(edits, tc.edits) || q0 != tc.q0 || q1 != tc.q1 {
+				t.Errorf("completionEdits returned %v, %v, %v; want %v, %v, %v", edits, q0, q1, tc.edits, tc.q0, tc.q1)
+			}
+		})
+	}
+}
+
+func TestFuzzyPrefixMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "Println", true},
+		{"pln", "Println", true},
+		{"PrLn", "println", true},
+		{"rln", "Println", false},
+		{"plx", "Println", false},
+		{"println2", "Println", false},
+	} {
+		if got := fuzzyPrefixMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("fuzzyPrefixMatch(%q, %q) = %v; want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+type completionServer struct {
+	proxy.NotImplementedServer
+}
+
+func (s *completionServer) Version(context.Context) (int, error) {
+	return proxy.Version, nil
+}
+
+func (s *completionServer) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder, error) {
+	return nil, nil
+}
+
+func (s *completionServer) InitializeResult(context.Context, *protocol.TextDocumentIdentifier) (*protocol.InitializeResult, error) {
+	return &protocol.InitializeResult{}, nil
+}
+
+func (s *completionServer) ExecuteCommandOnDocument(context.Context, *proxy.ExecuteCommandOnDocumentParams) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *completionServer) SyncDocument(context.Context, *proxy.SyncDocumentParams) error {
+	return nil
+}
+
+func (s *completionServer) ExecuteCommandOnServer(context.Context, *proxy.ExecuteCommandOnServerParams) (interface{}, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *completionServer) ResolveCompletionItemOnDocument(context.Context, *proxy.ResolveCompletionItemOnDocumentParams) (*protocol.CompletionItem, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *completionServer) Commands(context.Context) ([]proxy.ServerCommands, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *completionServer) Diagnostics(context.Context, *proxy.DiagnosticsParams) ([]protocol.PublishDiagnosticsParams, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *completionServer) WorkspaceSymbols(context.Context, *proxy.WorkspaceSymbolsParams) ([]protocol.SymbolInformation, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestCompletionInserter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(filename, []byte("x := fmt.Pr\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := text.NewHeadlessFile(filename, 11, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.CloseFiles()
+	ins, err := newCompletionInserter(&completionServer{}, f, &protocol.TextDocumentIdentifier{URI: text.ToURI(filename)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		item   protocol.CompletionItem
+		want   string
+		q0, q1 int
+	}{
+		{protocol.CompletionItem{Label: "Println"}, "x := fmt.Println\n", 16, 16},
+		{protocol.CompletionItem{Label: "Print"}, "x := fmt.Print\n", 14, 14},
+		{
+			protocol.CompletionItem{
+				Label: "Printf",
+				AdditionalTextEdits: []protocol.TextEdit{
+					{NewText: "import \"fmt\"\n"},
+				},
+			},
+			"import \"fmt\"\nx := fmt.Printf\n",
+			28, 28,
+		},
+		{protocol.CompletionItem{Label: "Print"}, "x := fmt.Print\n", 14, 14},
+	} {
+		if _, err := ins.insert(context.Background(), &tc.item); err != nil {
+			t.Fatalf("inserting %v failed: %v", tc.item.Label, err)
+		}
+		body, err := readBody(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != tc.want {
+			t.Errorf("after inserting %v, body is %q; want %q", tc.item.Label, body, tc.want)
+		}
+		if q0, q1, _ := f.CurrentAddr(); q0 != tc.q0 || q1 != tc.q1 {
+			t.Errorf("after inserting %v, dot is #%v,#%v; want #%v,#%v", tc.item.Label, q0, q1, tc.q0, tc.q1)
+		}
+	}
+
+	if _, err := f.WriteAt(0, 0, []byte("// edited\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ins.insert(context.Background(), &protocol.CompletionItem{Label: "Println"}); err == nil {
+		t.Errorf("inserting after the file was edited succeeded")
+	}
+}
+
+func TestFormatSignatureHelp(t *testing.T) {
+	params := func(labels ...string) []protocol.ParameterInformation {
+		var p []protocol.ParameterInformation
+		for _, l := range labels {
+			p = append(p, protocol.ParameterInformation{Label: l})
+		}
+		return p
+	}
+	for _, tc := range []struct {
+		name string
+		sh   protocol.SignatureHelp
+		want string
+	}{
+		{
+			"Documentation",
+			protocol.SignatureHelp{
+				Signatures: []protocol.SignatureInformation{
+					{
+						Label: "Println(a ...any) (n int, err error)",
+						Documentation: &protocol.Or_SignatureInformation_documentation{
+							Value: protocol.MarkupContent{Kind: protocol.Markdown, Value: "Println formats *stuff*."},
+						},
+						Parameters: params("a ...any"),
+					},
+				},
+			},
+			"Println(«a ...any») (n int, err error)\n\nPrintln formats stuff.\n",
+		},
+		{
+			"Overloads",
+			protocol.SignatureHelp{
+				Signatures: []protocol.SignatureInformation{
+					{Label: "f()"},
+					{
+						Label: "f(int x, int y)",
+						Parameters: []protocol.ParameterInformation{
+							{Label: "int x"},
+							{
+								Label: "int y",
+								Documentation: &protocol.Or_ParameterInformation_documentation{
+									Value: "the y\nvalue",
+								},
+							},
+						},
+					},
+					{Label: "f(double x)", Parameters: params("double x")},
+				},
+				ActiveSignature: 1,
+				ActiveParameter: 1,
+			},
+			"2. f(int x, «int y»)\n\nint y\n\tthe y\n\tvalue\n\n1. f()\n3. f(double x)\n",
+		},
+		{
+			"SignatureActiveParameter",
+			protocol.SignatureHelp{
+				Signatures: []protocol.SignatureInformation{
+					{Label: "x(x, y)", Parameters: params("x", "y"), ActiveParameter: 1},
+				},
+				ActiveSignature: 3,
+			},
+			"x(x, «y»)\n",
+		},
+		{
+			"NoActiveParameter",
+			protocol.SignatureHelp{
+				Signatures: []protocol.SignatureInformation{
+					{Label: "f(x)", Parameters: params("x")},
+				},
+				ActiveParameter: 1,
+			},
+			"f(x)\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := formatSignatureHelp(&tc.sh); got != tc.want {
+				t.Errorf("signature help is %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSignatureHelp(t *testing.T) {
+	for _, tc := range []struct {
+		name, json, want string
+	}{
+		{
+			"SignatureActiveParameterZero",
+			`{"signatures": [{"label": "f(x, y)", "parameters": [{"label": "x"}, {"label": "y"}], "activeParameter": 0}], "activeParameter": 1}`,
+			"f(«x», y)\n",
+		},
+		{
+			"NoSignatureActiveParameter",
+			`{"signatures": [{"label": "f(x, y)", "parameters": [{"label": "x"}, {"label": "y"}]}], "activeParameter": 1}`,
+			"f(x, «y»)\n",
+		},
+		{
+			"OtherSignatureActiveParameter",
+			`{"signatures": [{"label": "f(x)", "parameters": [{"label": "x"}], "activeParameter": 0}, {"label": "g(x, y)", "parameters": [{"label": "x"}, {"label": "y"}]}], "activeSignature": 1, "activeParameter": 1}`,
+			"2. g(x, «y»)\n\n1. f(x)\n",
+		},
+		{
+			"ActiveSignatureOutOfRange",
+			`{"signatures": [{"label": "f(x, y)", "parameters": [{"label": "x"}, {"label": "y"}], "activeParameter": 0}], "activeSignature": 5, "activeParameter": 1}`,
+			"f(«x», y)\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sh, err := decodeSignatureHelp([]byte(tc.json))
+			if err != nil {
+				t.Fatalf("decodeSignatureHelp failed: %v", err)
+			}
+			if got := formatSignatureHelp(sh); got != tc.want {
+				t.Errorf("signature help is %q; want %q", got, tc.want)
+			}
+		})
+	}
+	if sh, err := decodeSignatureHelp([]byte("null")); sh != nil || err != nil {
+		t.Errorf("decodeSignatureHelp(null) is %v, %v; want nil, nil", sh, err)
+	}
+}
+
+// callServer is a server whose call graph is given by callees, which
+// maps a function to the functions it calls.
+type callServer struct {
+	completionServer
+	callees map[string][]string
+}
+
+func (s *callServer) OutgoingCalls(ctx context.Context, params *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
+	var calls []protocol.CallHierarchyOutgoingCall
+	for _, name := range s.callees[params.Item.Name] {
+		calls = append(calls, protocol.CallHierarchyOutgoingCall{
+			To: protocol.CallHierarchyItem{
+				Name: name,
+				URI:  protocol.DocumentURI("file:///src/" + name + ".go"),
+			},
+		})
+	}
+	return calls, nil
+}
+
+func TestWalkCalls(t *testing.T) {
+	var buf strings.Builder
+	server := &callServer{
+		callees: map[string][]string{
+			"main": {"f", "g"},
+			"f":    {"h"},
+			"g":    {"h", "main"},
+			"h":    {"i"},
+		},
+	}
+	rc := &RemoteCmd{server: server, Stdout: &buf}
+	item := &protocol.CallHierarchyItem{
+		Name: "main",
+		URI:  "file:///src/main.go",
+	}
+	seen := map[string]int{callHierarchyKey(item): 0}
+	err := rc.walkCalls(context.Background(), item, false, 1, 3, seen, "/src")
+	if err != nil {
+		t.Fatalf("walkCalls failed: %v", err)
+	}
+	want := "\tf\tf.go:1.1,1.1\n" +
+		"\t\th\th.go:1.1,1.1\n" +
+		"\t\t\ti\ti.go:1.1,1.1\n" +
+		"\tg\tg.go:1.1,1.1\n" +
+		"\t\th (already shown)\th.go:1.1,1.1\n" +
+		"\t\tmain (already shown)\tmain.go:1.1,1.1\n"
+	if got := buf.String(); got != want {
+		t.Errorf("walkCalls printed %q; want %q", got, want)
+	}
+}
+
+// typeServer is a server whose type hierarchy is given by supertypes,
+// which maps a type to its supertypes.
+type typeServer struct {
+	completionServer
+	supertypes map[string][]string
+	calls      int
+}
+
+func (s *typeServer) Supertypes(ctx context.Context, params *protocol.TypeHierarchySupertypesParams) ([]protocol.TypeHierarchyItem, error) {
+	s.calls++
+	var items []protocol.TypeHierarchyItem
+	for _, name := range s.supertypes[params.Item.Name] {
+		items = append(items, protocol.TypeHierarchyItem{
+			Name: name,
+			URI:  protocol.DocumentURI("file:///src/" + name + ".go"),
+		})
+	}
+	return items, nil
+}
+
+func TestWalkTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		supertypes map[string][]string
+		want       string
+		calls      int
+	}{
+		{
+			name: "diamond",
+			supertypes: map[string][]string{
+				"D": {"B", "C"},
+				"B": {"A"},
+				"C": {"A"},
+				"A": {"O"},
+			},
+			want: "\tB\tB.go:1.1,1.1\n" +
+				"\t\tA\tA.go:1.1,1.1\n" +
+				"\t\t\tO\tO.go:1.1,1.1\n" +
+				"\tC\tC.go:1.1,1.1\n" +
+				"\t\tA (already shown)\tA.go:1.1,1.1\n",
+			calls: 5,
+		},
+		{
+			name: "shallower",
+			supertypes: map[string][]string{
+				"D": {"B", "A"},
+				"B": {"A"},
+				"A": {"O"},
+			},
+			want: "\tB\tB.go:1.1,1.1\n" +
+				"\t\tA\tA.go:1.1,1.1\n" +
+				"\t\t\tO\tO.go:1.1,1.1\n" +
+				"\tA\tA.go:1.1,1.1\n" +
+				"\t\tO\tO.go:1.1,1.1\n",
+			calls: 6,
+		},
+		{
+			name: "cycle",
+			supertypes: map[string][]string{
+				"D": {"B"},
+				"B": {"D"},
+			},
+			want: "\tB\tB.go:1.1,1.1\n" +
+				"\t\tD (already shown)\tD.go:1.1,1.1\n",
+			calls: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			server := &typeServer{supertypes: tc.supertypes}
+			rc := &RemoteCmd{server: server, Stdout: &buf}
+			item := &protocol.TypeHierarchyItem{
+				Name: "D",
+				URI:  "file:///src/D.go",
+			}
+			seen := map[string]int{typeHierarchyKey(item): 0}
+			err := rc.walkTypes(context.Background(), item, true, 1, math.MaxInt32, seen, "/src")
+			if err != nil {
+				t.Fatalf("walkTypes failed: %v", err)
+			}
+			if got := buf.String(); got != tc.want {
+				t.Errorf("walkTypes printed %q; want %q", got, tc.want)
+			}
+			if server.calls != tc.calls {
+				t.Errorf("walkTypes made %v requests; want %v", server.calls, tc.calls)
+			}
+		})
+	}
+}
+
+func TestDocumentSymbolTree(t *testing.T) {
+	rng := func(l0, c0, l1, c1 uint32) protocol.Range {
+		return protocol.Range{
+			Start: protocol.Position{Line: l0, Character: c0},
+			End:   protocol.Position{Line: l1, Character: c1},
+		}
+	}
+	symbolInformation := func(name string, r protocol.Range) interface{} {
+		return map[string]interface{}{
+			"name": name,
+			"kind": 12,
+			"location": map[string]interface{}{
+				"uri": "file:///a.py",
+				"range": map[string]interface{}{
+					"start": map[string]interface{}{"line": r.Start.Line, "character": r.Start.Character},
+					"end":   map[string]interface{}{"line": r.End.Line, "character": r.End.Character},
+				},
+			},
+		}
+	}
+	// Symbols as returned by pylsp: flat and not necessarily sorted.
+	result := []interface{}{
+		symbolInformation("method", rng(2, 4, 4, 0)),
+		symbolInformation("Class", rng(1, 0, 6, 0)),
+		symbolInformation("inner", rng(3, 8, 3, 20)),
+		symbolInformation("other", rng(5, 4, 5, 10)),
+		symbolInformation("f", rng(7, 0, 9, 0)),
+	}
+	syms := documentSymbolTree(result)
+
+	var got []string
+	walkDocumentSymbols(syms, 0, func(s *protocol.DocumentSymbol, depth int) {
+		got = append(got, strings.Repeat(" ", depth)+s.Name)
+	})
+	want := []string{"Class", " method", "  inner", " other", "f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("symbol tree is %q; want %q", got, want)
+	}
+
+	for _, tc := range []struct {
+		pos  protocol.Position
+		want []string
+	}{
+		{protocol.Position{Line: 3, Character: 10}, []string{"Class", "method", "inner"}},
+		{protocol.Position{Line: 5, Character: 0}, []string{"Class"}},
+		{protocol.Position{Line: 8, Character: 2}, []string{"f"}},
+		{protocol.Position{Line: 0, Character: 0}, nil},
+	} {
+		var names []string
+		for _, s := range enclosingSymbols(syms, tc.pos) {
+			names = append(names, s.Name)
+		}
+		if !reflect.DeepEqual(names, tc.want) {
+			t.Errorf("enclosing symbols at %v are %q; want %q", tc.pos, names, tc.want)
+		}
+	}
+}
+
+func TestRankSymbols(t *testing.T) {
+	symbol := func(name string, line uint32) workspaceSymbol {
+		return workspaceSymbol{SymbolInformation: protocol.SymbolInformation{
+			Location: protocol.Location{
+				URI: "file:///a.go",
+				Range: protocol.Range{
+					Start: protocol.Position{Line: line},
+					End:   protocol.Position{Line: line},
+				},
+			},
+			BaseSymbolInformation: protocol.BaseSymbolInformation{
+				Name: name,
+				Kind: protocol.Function,
+			},
+		}}
+	}
+	symbols := []workspaceSymbol{
+		symbol("myHelloWorld", 1),
+		symbol("helloWorld", 2),
+		symbol("HelloWorld", 3),
+		symbol("T.Hello", 4),
+		symbol("hello", 5),
+		symbol("HelloWorld", 3), // from a second server
+		symbol("Hello", 6),
+		symbol("xyz", 7),
+	}
+	var got []string
+	for _, s := range rankSymbols("Hello", dedupSymbols(symbols)) {
+		got = append(got, s.Name)
+	}
+	want := []string{"T.Hello", "Hello", "hello", "HelloWorld", "helloWorld", "myHelloWorld", "xyz"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ranked symbols are %q; want %q", got, want)
+	}
+}
+
+// resolveServer records the workspace symbols it resolves.
+type resolveServer struct {
+	proxy.NotImplementedServer
+	resolved []string
+}
+
+func (s *resolveServer) ResolveWorkspaceSymbol(ctx context.Context, params *protocol.WorkspaceSymbol) (*protocol.WorkspaceSymbol, error) {
+	s.resolved = append(s.resolved, params.Name)
+	return params, nil
+}
+
+func TestSelectWorkspaceSymbols(t *testing.T) {
+	server := &resolveServer{}
+	client := &Client{Server: server}
+	symbol := func(name string, kind protocol.SymbolKind) workspaceSymbol {
+		base := protocol.BaseSymbolInformation{Name: name, Kind: kind}
+		return workspaceSymbol{
+			SymbolInformation: protocol.SymbolInformation{BaseSymbolInformation: base},
+			unresolved:        &protocol.WorkspaceSymbol{BaseSymbolInformation: base},
+			client:            client,
+		}
+	}
+	symbols := []workspaceSymbol{
+		symbol("Helper", protocol.Function),
+		symbol("Hello", protocol.Struct),
+		symbol("HelloWorld", protocol.Function),
+		symbol("Hello", protocol.Function),
+	}
+	params := &proxy.WorkspaceSymbolsParams{
+		WorkspaceSymbolParams: protocol.WorkspaceSymbolParams{Query: "Hello"},
+		Kinds:                 []protocol.SymbolKind{protocol.Function},
+		Limit:                 2,
+	}
+	result, err := resolveWorkspaceSymbols(context.Background(), selectWorkspaceSymbols(params, symbols))
+	if err != nil {
+		t.Fatalf("resolveWorkspaceSymbols failed: %v", err)
+	}
+	var got []string
+	for _, s := range result {
+		got = append(got, s.Name)
+	}
+	want := []string{"Hello", "HelloWorld"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selected symbols are %q; want %q", got, want)
+	}
+	if !reflect.DeepEqual(server.resolved, want) {
+		t.Errorf("resolved symbols %q; want %q", server.resolved, want)
+	}
+}
+
+func TestParseSymbolKinds(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want []protocol.SymbolKind
+		err  bool
+	}{
+		{"struct", []protocol.SymbolKind{protocol.Struct}, false},
+		{"func, Interface", []protocol.SymbolKind{protocol.Function, protocol.Method, protocol.Constructor, protocol.Interface}, false},
+		{"struct,nope", nil, true},
+	} {
+		got, err := ParseSymbolKinds(tc.s)
+		if (err != nil) != tc.err {
+			t.Errorf("ParseSymbolKinds(%q) returned error %v", tc.s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ParseSymbolKinds(%q) = %v; want %v", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestSubstituteArgs(t *testing.T) {
+	vars := func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"uri": protocol.DocumentURI("file:///a/b.go"),
+			"position": protocol.Position{
+				Line:      1,
+				Character: 2,
+			},
+		}, nil
+	}
+	for _, tc := range []struct {
+		arg, want string
+	}{
+		{`"fmt"`, `"fmt"`},
+		{`${uri}`, `"file:///a/b.go"`},
+		{`"${uri}"`, `"file:///a/b.go"`},
+		{`{"URI": "${uri}", "Pos": ${position}}`, `{"URI": "file:///a/b.go", "Pos": {"line":1,"character":2}}`},
+		{`"file ${uri}"`, `"file file:///a/b.go"`},
+		{`["${uri}#L1", "\"${uri}"]`, `["file:///a/b.go#L1", "\"file:///a/b.go"]`},
+		{`"\\${uri}"`, `"\\file:///a/b.go"`},
+		{`"quote\"" ${uri}`, `"quote\"" "file:///a/b.go"`},
+		{`"${uri}${uri}"`, `"file:///a/b.gofile:///a/b.go"`},
+		{`"odd \u0024{uri}"`, `"odd \u0024{uri}"`},
+	} {
+		got, err := substituteArgs(tc.arg, vars)
+		if err != nil {
+			t.Errorf("substituteArgs(%q) failed: %v", tc.arg, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("substituteArgs(%q) is %q; want %q", tc.arg, got, tc.want)
+		}
+	}
+
+	if _, err := substituteArgs(`${range}`, vars); err == nil {
+		t.Errorf("substituteArgs succeeded for unknown placeholder")
+	}
+	if _, err := substituteArgs(`"at ${position}"`, vars); err == nil {
+		t.Errorf("substituteArgs succeeded for non-string placeholder within a string")
+	}
+	novars := func() (map[string]interface{}, error) {
+		return nil, fmt.Errorf("no window")
+	}
+	if got, err := substituteArgs(`["a"]`, novars); err != nil || got != `["a"]` {
+		t.Errorf("substituteArgs without placeholders is %q, %v", got, err)
+	}
+}
+
+func TestWriteDiagnostics(t *testing.T) {
+	rng := func(line, col uint32) protocol.Range {
+		return protocol.Range{
+			Start: protocol.Position{Line: line, Character: col},
+			End:   protocol.Position{Line: line, Character: col + 1},
+		}
+	}
+	diags := map[protocol.DocumentURI][]protocol.Diagnostic{
+		"file:///ws/b.go": {
+			{Range: rng(0, 0), Message: "no severity"},
+		},
+		"file:///ws/a/a.go": {
+			{Range: rng(4, 1), Severity: protocol.SeverityHint, Message: "hint", Source: "simplifycompositelit"},
+			{Range: rng(4, 0), Severity: protocol.SeverityWarning, Message: "warning", Source: "staticcheck", Code: "SA4006"},
+			{
+				Range:    rng(2, 0),
+				Severity: protocol.SeverityError,
+				Message:  "x declared and not used\nsecond line",
+				Source:   "compiler",
+				Code:     "UnusedVar",
+				RelatedInformation: []protocol.DiagnosticRelatedInformation{
+					{
+						Location: protocol.Location{URI: "file:///ws/b.go", Range: rng(1, 2)},
+						Message:  "related",
+					},
+				},
+			},
+			{Range: rng(4, 2), Severity: protocol.SeverityError, Message: "error", Code: float64(2304)},
+		},
+		"file:///other/c.go": {
+			{Range: rng(0, 0), Severity: protocol.SeverityInformation, Message: "info"},
+		},
+	}
+	want := `/other/c.go:1.1,1.2: I: info
+a/a.go:3.1,3.2: E compiler(UnusedVar): x declared and not used
+		second line
+	b.go:2.3,2.4: related
+a/a.go:5.3,5.4: E 2304: error
+a/a.go:5.1,5.2: W staticcheck(SA4006): warning
+a/a.go:5.2,5.3: H simplifycompositelit: hint
+b.go:1.1,1.2: E: no severity
+`
+	for i := 0; i < 3; i++ {
+		var sb strings.Builder
+		lines := writeDiagnostics(&sb, diags, []string{"/w", "/ws"})
+		if got := sb.String(); got != want {
+			t.Fatalf("writeDiagnostics wrote\n%v\nwant\n%v", got, want)
+		}
+		if got, want := len(lines), strings.Count(want, "\n"); got != want {
+			t.Fatalf("writeDiagnostics returned %v lines; want %v", got, want)
+		}
+		for i := 1; i <= 3; i++ {
+			if d := lines[i]; d.uri != "file:///ws/a/a.go" || d.Code != "UnusedVar" {
+				t.Errorf("diagnostic on line %v is %v %v; want UnusedVar in a.go", i, d.uri, d.Code)
+			}
+		}
+	}
+}
+
+func TestDiagnosticsFilter(t *testing.T) {
+	diags := []protocol.Diagnostic{
+		{Severity: protocol.SeverityError, Source: "compiler", Message: "undefined: x"},
+		{Severity: protocol.SeverityWarning, Source: "staticcheck", Message: "SA4006: unused value"},
+		{Severity: protocol.SeverityHint, Source: "simplifycompositelit", Message: "redundant type"},
+		{Message: "no severity"},
+	}
+	for _, tc := range []struct {
+		name   string
+		filter *config.DiagnosticsFilter
+		uri    protocol.DocumentURI
+		want   []string // messages
+	}{
+		{"Nil", nil, "file:///a.go", []string{"undefined: x", "SA4006: unused value", "redundant type", "no severity"}},
+		{"Empty", &config.DiagnosticsFilter{}, "file:///a.go", []string{"undefined: x", "SA4006: unused value", "redundant type", "no severity"}},
+		{"MinSeverity", &config.DiagnosticsFilter{MinSeverity: "warning"}, "file:///a.go", []string{"undefined: x", "SA4006: unused value", "no severity"}},
+		{"IncludeSources", &config.DiagnosticsFilter{IncludeSources: []string{"compiler", "staticcheck"}}, "file:///a.go", []string{"undefined: x", "SA4006: unused value"}},
+		{"ExcludeSources", &config.DiagnosticsFilter{ExcludeSources: []string{"simplifycompositelit"}}, "file:///a.go", []string{"undefined: x", "SA4006: unused value", "no severity"}},
+		{"ExcludeMessage", &config.DiagnosticsFilter{ExcludeMessage: "^SA[0-9]+:"}, "file:///a.go", []string{"undefined: x", "redundant type", "no severity"}},
+		{"ExcludePath", &config.DiagnosticsFilter{ExcludePath: "_test\\.go$"}, "file:///a_test.go", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := newDiagnosticsFilter(tc.filter)
+			if err != nil {
+				t.Fatalf("newDiagnosticsFilter failed: %v", err)
+			}
+			p := f.filter(&protocol.PublishDiagnosticsParams{
+				URI:         tc.uri,
+				Diagnostics: diags,
+			})
+			var got []string
+			for _, d := range p.Diagnostics {
+				got = append(got, d.Message)
+			}
+			if !cmp.Equal(got, tc.want) {
+				t.Errorf("filtered diagnostics are %q; want %q", got, tc.want)
+			}
+		})
+	}
+
+	for _, f := range []*config.DiagnosticsFilter{
+		{MinSeverity: "fatal"},
+		{ExcludeMessage: "("},
+		{ExcludePath: "["},
+	} {
+		if _, err := newDiagnosticsFilter(f); err == nil {
+			t.Errorf("newDiagnosticsFilter(%+v) succeeded for invalid filter", f)
+		}
+	}
+}
+
+func TestNextAddr(t *testing.T) {
+	addrs := [][2]int{{30, 35}, {10, 12}, {20, 25}, {10, 11}}
+	for _, tc := range []struct {
+		q0   int
+		prev bool
+		want [2]int
+	}{
+		{0, false, [2]int{10, 11}},
+		{10, false, [2]int{20, 25}},
+		{25, false, [2]int{30, 35}},
+		{30, false, [2]int{10, 11}},
+		{0, true, [2]int{30, 35}},
+		{10, true, [2]int{30, 35}},
+		{20, true, [2]int{10, 12}},
+		{40, true, [2]int{30, 35}},
+	} {
+		if got := nextAddr(addrs, tc.q0, tc.prev); got != tc.want {
+			t.Errorf("nextAddr(%v, %v) is %v; want %v", tc.q0, tc.prev, got, tc.want)
+		}
+	}
+}
+
+func TestDiagnosticMarker(t *testing.T) {
+	for _, tc := range []struct {
+		severities []protocol.DiagnosticSeverity
+		want       string
+	}{
+		{nil, ""},
+		{[]protocol.DiagnosticSeverity{protocol.SeverityHint, protocol.SeverityInformation}, ""},
+		{[]protocol.DiagnosticSeverity{protocol.SeverityError, 0, protocol.SeverityError}, "E:3"},
+		{[]protocol.DiagnosticSeverity{protocol.SeverityWarning}, "W:1"},
+		{[]protocol.DiagnosticSeverity{protocol.SeverityWarning, protocol.SeverityError, protocol.SeverityHint}, "E:1 W:1"},
+	} {
+		var diags []protocol.Diagnostic
+		for _, s := range tc.severities {
+			diags = append(diags, protocol.Diagnostic{Severity: s})
+		}
+		if got := diagnosticMarker(diags); got != tc.want {
+			t.Errorf("diagnosticMarker(%v) is %q; want %q", tc.severities, got, tc.want)
+		}
+	}
+}
+
+func TestTagUserText(t *testing.T) {
+	for _, tc := range []struct {
+		tag, want string
+	}{
+		{"/a/b.go Del Snarf | Look ", " Look "},
+		{"/a/b|c.go Del Snarf | Look", " Look"},
+		{"/a/b.go Del Snarf |", ""},
+		{"/a/b.go Del Snarf", "none"},
+		{"'/a/b c|d.go' Del Snarf | Look", " Look"},
+		{"'/a/it''s |.go' Del | Look", " Look"},
+		{"'/a/b c.go'", "none"},
+	} {
+		got := "none"
+		if i := tagUserText(tc.tag); i >= 0 {
+			got = tc.tag[i:]
+		}
+		if got != tc.want {
+			t.Errorf("user text of tag %q is %q; want %q", tc.tag, got, tc.want)
+		}
+	}
+}
+
+func TestReplaceTagMarker(t *testing.T) {
+	for _, tc := range []struct {
+		s, old, new, want string
+	}{
+		{"", "", "E:1", " E:1"},
+		{" E:1", "E:1", "", ""},
+		{" Look ", "", "E:3 W:1", " Look E:3 W:1 "},
+		{" Look E:3 W:1 ", "E:3 W:1", "W:2", " Look W:2 "},
+		{" Look E:3 W:1 ", "E:3 W:1", "", " Look "},
+		{" Look", "", "E:1", " Look E:1"},
+		{" Look E:1", "E:1", "", " Look"},
+		{" Look E:1 Get", "E:1", "E:2", " Look Get E:2"},
+		{" XE:1 E:1x", "E:1", "E:2", " XE:1 E:1x E:2"},
+		{" E:1 Look E:1", "E:1", "", " E:1 Look"},
+		{" Look", "E:1", "", " Look"},
+	} {
+		if got := replaceTagMarker(tc.s, tc.old, tc.new); got != tc.want {
+			t.Errorf("replaceTagMarker(%q, %q, %q) is %q; want %q", tc.s, tc.old, tc.new, got, tc.want)
+		}
+	}
+}
+
+func TestFindTagMarker(t *testing.T) {
+	for _, tc := range []struct {
+		s, want string
+	}{
+		{" Look ", ""},
+		{" Look E:3 W:1 ", "E:3 W:1"},
+		{" Look W:2", "W:2"},
+		{" E:1 Look E:2", "E:2"},
+		{" XE:1 E:1x", ""},
+	} {
+		if got := findTagMarker(tc.s); got != tc.want {
+			t.Errorf("findTagMarker(%q) is %q; want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+// pullServer is a language server that only supports pulling
+// diagnostics. Document pulls are answered with reports, in turn.
+type pullServer struct {
+	protocol.Server
+	reports   []protocol.DocumentDiagnosticReport
+	workspace protocol.WorkspaceDiagnosticReport
+	hold      chan struct{} // if not nil, workspace pulls wait until it's closed
+
+	mu             sync.Mutex
+	pulls          []protocol.DocumentDiagnosticParams
+	workspacePulls []protocol.WorkspaceDiagnosticParams
+}
+
+func (s *pullServer) DidOpen(context.Context, *protocol.DidOpenTextDocumentParams) error {
+	return nil
+}
+
+func (s *pullServer) DidChange(context.Context, *protocol.DidChangeTextDocumentParams) error {
+	return nil
+}
+
+func (s *pullServer) DidClose(context.Context, *protocol.DidCloseTextDocumentParams) error {
+	return nil
+}
+
+func (s *pullServer) Diagnostic(_ context.Context, params *protocol.DocumentDiagnosticParams) (*protocol.DocumentDiagnosticReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pulls = append(s.pulls, *params)
+	if len(s.reports) == 0 {
+		return nil, fmt.Errorf("no more reports")
+	}
+	r := s.reports[0]
+	s.reports = s.reports[1:]
+	return &r, nil
+}
+
+func (s *pullServer) DiagnosticWorkspace(_ context.Context, params *protocol.WorkspaceDiagnosticParams) (*protocol.WorkspaceDiagnosticReport, error) {
+	s.mu.Lock()
+	s.workspacePulls = append(s.workspacePulls, *params)
+	s.mu.Unlock()
+	if s.hold != nil {
+		<-s.hold
+	}
+	return &s.workspace, nil
+}
+
+func (s *pullServer) requests() ([]protocol.DocumentDiagnosticParams, []protocol.WorkspaceDiagnosticParams) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]protocol.DocumentDiagnosticParams(nil), s.pulls...),
+		append([]protocol.WorkspaceDiagnosticParams(nil), s.workspacePulls...)
+}
+
+type recordingDiagnosticsWriter struct {
+	mu        sync.Mutex
+	published []protocol.PublishDiagnosticsParams
+}
+
+func (dw *recordingDiagnosticsWriter) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.published = append(dw.published, *params)
+}
+
+// newPullClient returns a client connected to server, which pulls
+// diagnostics and possibly workspace diagnostics.
+func newPullClient(t *testing.T, server protocol.Server, workspace bool) (*Client, *recordingDiagnosticsWriter) {
+	var result protocol.InitializeResult
+	caps := fmt.Sprintf(`{"capabilities": {"diagnosticProvider": {"interFileDependencies": true, "workspaceDiagnostics": %v}}}`, workspace)
+	if err := json.Unmarshal([]byte(caps), &result); err != nil {
+		t.Fatalf("failed to decode initialize result: %v", err)
+	}
+	dw := &recordingDiagnosticsWriter{}
+	cfg := &ClientConfig{
+		Server:     &config.Server{},
+		DiagWriter: dw,
+	}
+	c := &Client{
+		Server:           server,
+		initializeResult: &result,
+		cfg:              cfg,
+		openDocs:         make(map[protocol.DocumentURI]docState),
+		hintRanges:       make(map[protocol.DocumentURI]protocol.Range),
+		pulls:            make(map[protocol.DocumentURI]*pendingPull),
+	}
+	c.handler = &clientHandler{
+		cfg:        cfg,
+		diagWriter: dw,
+		diag:       make(map[protocol.DocumentURI][]protocol.Diagnostic),
+		resultIDs:  make(map[protocol.DocumentURI]string),
+		client:     c,
+	}
+	return c, dw
+}
+
+// waitPulls waits until the client has no pending document pulls.
+func waitPulls(t *testing.T, c *Client) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c.mu.Lock()
+		n := len(c.pulls)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+	}
+	t.Fatalf("diagnostics pulls didn't complete")
+}
+
+func fullReport(id string, msgs ...string) protocol.DocumentDiagnosticReport {
+	r := protocol.RelatedFullDocumentDiagnosticReport{}
+	r.Kind = "full"
+	r.ResultID = id
+	r.Items = []protocol.Diagnostic{}
+	for _, m := range msgs {
+		r.Items = append(r.Items, protocol.Diagnostic{Message: m})
+	}
+	return protocol.DocumentDiagnosticReport{Value: r}
+}
+
+func unchangedReport(id string) protocol.DocumentDiagnosticReport {
+	r := protocol.RelatedUnchangedDocumentDiagnosticReport{}
+	r.Kind = "unchanged"
+	r.ResultID = id
+	return protocol.DocumentDiagnosticReport{Value: r}
+}
+
+func TestPullDiagnostics(t *testing.T) {
+	const uri = protocol.DocumentURI("file:///a/b.go")
+
+	for _, tc := range []struct {
+		name          string
+		reports       []protocol.DocumentDiagnosticReport
+		wantPrevious  []string   // previous result ids sent by each pull
+		wantPublished [][]string // messages of the published diagnostics
+		wantResultID  string
+	}{
+		{
+			"ResultIDTracking",
+			[]protocol.DocumentDiagnosticReport{fullReport("1", "a"), fullReport("2", "b", "c")},
+			[]string{"", "1"},
+			[][]string{{"a"}, {"b", "c"}},
+			"2",
+		},
+		{
+			"Unchanged",
+			[]protocol.DocumentDiagnosticReport{fullReport("1", "a"), unchangedReport("1"), fullReport("3")},
+			[]string{"", "1", "1"},
+			[][]string{{"a"}, {}},
+			"3",
+		},
+		{
+			"NoResultID",
+			[]protocol.DocumentDiagnosticReport{fullReport("1", "a"), fullReport("", "b")},
+			[]string{"", "1"},
+			[][]string{{"a"}, {"b"}},
+			"",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := &pullServer{reports: tc.reports}
+			c, dw := newPullClient(t, server, false)
+			ctx := context.Background()
+			for i := range tc.reports {
+				err := c.SyncDocument(ctx, &proxy.SyncDocumentParams{
+					TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+					Content:      fmt.Sprintf("package b // %v\n", i),
+				})
+				if err != nil {
+					t.Fatalf("SyncDocument failed: %v", err)
+				}
+				waitPulls(t, c)
+			}
+
+			pulls, _ := server.requests()
+			var previous []string
+			for _, p := range pulls {
+				previous = append(previous, p.PreviousResultID)
+			}
+			if !reflect.DeepEqual(previous, tc.wantPrevious) {
+				t.Errorf("previous result ids are %q; want %q", previous, tc.wantPrevious)
+			}
+			var published [][]string
+			for _, p := range dw.published {
+				msgs := []string{}
+				for _, d := range p.Diagnostics {
+					msgs = append(msgs, d.Message)
+				}
+				published = append(published, msgs)
+			}
+			if !reflect.DeepEqual(published, tc.wantPublished) {
+				t.Errorf("published diagnostics are %q; want %q", published, tc.wantPublished)
+			}
+			if got := c.handler.resultID(uri); got != tc.wantResultID {
+				t.Errorf("result id is %q; want %q", got, tc.wantResultID)
+			}
+		})
+	}
+}
+
+func TestPullDiagnosticsDebounce(t *testing.T) {
+	const uri = protocol.DocumentURI("file:///a/b.go")
+	server := &pullServer{reports: []protocol.DocumentDiagnosticReport{fullReport("1")}}
+	c, _ := newPullClient(t, server, false)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		err := c.SyncDocument(ctx, &proxy.SyncDocumentParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Content:      fmt.Sprintf("package b // %v\n", i),
+		})
+		if err != nil {
+			t.Fatalf("SyncDocument failed: %v", err)
+		}
+	}
+	waitPulls(t, c)
+	if pulls, _ := server.requests(); len(pulls) != 1 {
+		t.Errorf("burst of changes resulted in %v pulls; want 1", len(pulls))
+	}
+
+	// Closing the document cancels the pending pull.
+	err := c.SyncDocument(ctx, &proxy.SyncDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Content:      "package b\n",
+	})
+	if err != nil {
+		t.Fatalf("SyncDocument failed: %v", err)
+	}
+	err = c.DidClose(ctx, &protocol.DidCloseTextDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+	})
+	if err != nil {
+		t.Fatalf("DidClose failed: %v", err)
+	}
+	time.Sleep(2 * pullDelay)
+	if pulls, _ := server.requests(); len(pulls) != 1 {
+		t.Errorf("closed document was pulled; got %v pulls, want 1", len(pulls))
+	}
+}
+
+func TestPullWorkspaceDiagnostics(t *testing.T) {
+	const (
+		open   = protocol.DocumentURI("file:///a/open.go")
+		closed = protocol.DocumentURI("file:///a/closed.go")
+		same   = protocol.DocumentURI("file:///a/same.go")
+	)
+	full := func(uri protocol.DocumentURI, id, msg string) protocol.WorkspaceDocumentDiagnosticReport {
+		r := protocol.WorkspaceFullDocumentDiagnosticReport{URI: uri}
+		r.Kind = "full"
+		r.ResultID = id
+		r.Items = []protocol.Diagnostic{{Message: msg}}
+		return protocol.WorkspaceDocumentDiagnosticReport{Value: r}
+	}
+	unchanged := protocol.WorkspaceUnchangedDocumentDiagnosticReport{URI: same}
+	unchanged.Kind = "unchanged"
+	unchanged.ResultID = "7"
+	server := &pullServer{
+		workspace: protocol.WorkspaceDiagnosticReport{
+			Items: []protocol.WorkspaceDocumentDiagnosticReport{
+				full(open, "1", "stale"),
+				full(closed, "2", "x"),
+				{Value: unchanged},
+			},
+		},
+		hold: make(chan struct{}),
+	}
+	c, dw := newPullClient(t, server, true)
+	c.openDocs[open] = docState{version: 1}
+
+	done := make(chan struct{})
+	go func() {
+		c.pullWorkspaceDiagnostics(context.Background())
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ws := server.requests(); len(ws) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("workspace diagnostics weren't pulled")
+		}
+	}
+
+	// The server holds the first request, so no other request is made.
+	c.pullWorkspaceDiagnostics(context.Background())
+	if _, ws := server.requests(); len(ws) != 1 {
+		t.Errorf("%v workspace pulls in flight; want 1", len(ws))
+	}
+	close(server.hold)
+	<-done
+
+	if len(dw.published) != 1 || dw.published[0].URI != closed {
+		t.Errorf("published diagnostics are %v; want only those of %v", dw.published, closed)
+	}
+	c.pullWorkspaceDiagnostics(context.Background())
+	_, ws := server.requests()
+	if len(ws) != 2 {
+		t.Fatalf("%v workspace pulls after the first one completed; want 2", len(ws))
+	}
+	ids := make(map[protocol.DocumentURI]string)
+	for _, id := range ws[1].PreviousResultIds {
+		ids[id.URI] = id.Value
+	}
+	want := map[protocol.DocumentURI]string{closed: "2", same: "7"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("previous result ids are %v; want %v", ids, want)
+	}
+}
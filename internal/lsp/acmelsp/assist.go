@@ -2,12 +2,9 @@ package acmelsp
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"strings"
 	"time"
 	"unicode"
 
@@ -114,6 +111,10 @@ type outputWin struct {
 	body  io.Writer
 	event <-chan *acme.Event
 	sm    ServerMatcher
+
+	// Signature help currently shown and the window it's for.
+	sig   *protocol.SignatureHelp
+	sigID int
 }
 
 func newOutputWin(sm ServerMatcher, name string) (*outputWin, error) {
@@ -227,6 +228,9 @@ func (w *outputWin) Update(fw *focusWin, server proxy.Server, cmd string) {
 	}
 
 	w.Clear()
+	if cmd != "sig" {
+		w.sig = nil
+	}
 	switch cmd {
 	case "comp":
 		err := rc.Completion(ctx, CompleteNoEdit)
@@ -235,7 +239,12 @@ func (w *outputWin) Update(fw *focusWin, server proxy.Server, cmd string) {
 		}
 
 	case "sig":
-		err = rc.SignatureHelp(ctx)
+		active := w.sig
+		if w.sigID != fw.id {
+			active = nil
+		}
+		w.sig, err = rc.signatureHelp(ctx, active)
+		w.sigID = fw.id
 		if err != nil {
 			dprintf("SignatureHelp failed: %v\n", err)
 		}
@@ -295,39 +304,6 @@ loop:
 	return nil
 }
 
-func Symbol(server proxy.Server, query string) error {
-	symbols, err := server.Symbol(context.Background(), &protocol.WorkspaceSymbolParams{Query: query})
-	if err != nil {
-		return err
-	}
-	var locations []protocol.Location
-	for _, symbol := range symbols {
-		locations = append(locations, symbol.Location)
-	}
-	return PrintLocations(os.Stdout, locations)
-}
-
-func Execute(server proxy.Server, serverID string, command string, args []string) error {
-	jargs := []json.RawMessage{}
-	for _, arg := range args {
-		var r json.RawMessage
-		err := json.NewDecoder(strings.NewReader(arg)).Decode(&r)
-		if err != nil {
-			return fmt.Errorf("could not parse argument %v: %v\n", arg, err)
-		}
-		jargs = append(jargs, r)
-	}
-
-	resp, err := server.ExecuteCommand(context.Background(), &protocol.ExecuteCommandParams{
-		Command:   command,
-		Arguments: jargs,
-	})
-	if err != nil {
-		return err
-	}
-	return json.NewEncoder(os.Stdout).Encode(resp)
-}
-
 // ServerMatcher represents a set of servers where it's possible to
 // find a matching server based on filename.
 type ServerMatcher interface {
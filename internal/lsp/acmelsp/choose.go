@@ -0,0 +1,65 @@
+package acmelsp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// choose shows the numbered list of items in an acme window named
+// name and waits for the user to pick one by looking (button 3) or
+// executing (button 2) anywhere on its line. It returns the index of
+// the chosen item, or -1 if the window was deleted without a choice.
+func choose(name string, items []string) (int, error) {
+	w, err := openWin(name)
+	if err != nil {
+		return -1, err
+	}
+	defer func() {
+		w.Del(true)
+		w.CloseFiles()
+	}()
+
+	var sb strings.Builder
+	for i, item := range items {
+		fmt.Fprintf(&sb, "%v\t%v\n", i+1, item)
+	}
+	if err := setWinBody(w, sb.String()); err != nil {
+		return -1, err
+	}
+
+	for ev := range w.EventChan() {
+		if ev == nil {
+			break
+		}
+		switch ev.C2 {
+		case 'X', 'L': // execute or look in body
+			b, err := w.ReadAll("body")
+			if err != nil {
+				return -1, err
+			}
+			if i := lineIndex([]rune(string(b)), ev.Q0); i < len(items) {
+				return i, nil
+			}
+		case 'x': // execute in tag
+			if string(ev.Text) == "Del" {
+				return -1, nil
+			}
+		}
+		w.WriteEvent(ev)
+	}
+	return -1, nil
+}
+
+// lineIndex returns the zero-based line number of rune offset q in body.
+func lineIndex(body []rune, q int) int {
+	if q > len(body) {
+		q = len(body)
+	}
+	n := 0
+	for _, r := range body[:q] {
+		if r == '\n' {
+			n++
+		}
+	}
+	return n
+}
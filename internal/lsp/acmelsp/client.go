@@ -2,10 +2,13 @@ package acmelsp
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"9fans.net/internal/go-lsp/lsp/protocol"
@@ -29,6 +32,9 @@ type clientHandler struct {
 	hideDiag   bool
 	diagWriter DiagnosticsWriter
 	diag       map[protocol.DocumentURI][]protocol.Diagnostic
+	resultIDs  map[protocol.DocumentURI]string // of the last pulled diagnostic reports
+	nshow      int                             // number of executing commands whose messages are shown
+	client     *Client
 	mu         sync.Mutex
 	proxy.NotImplementedClient
 }
@@ -39,9 +45,24 @@ func (h *clientHandler) ShowMessage(ctx context.Context, params *protocol.ShowMe
 	} else {
 		log.Printf("LSP %v: %v\n", params.Type, params.Message)
 	}
+	h.mu.Lock()
+	show := h.nshow > 0
+	h.mu.Unlock()
+	if show {
+		// Errors are ignored because acme may not be running (e.g. in headless mode).
+		appendWin(messagesWin, fmt.Sprintf("%v: %v\n", params.Type, params.Message))
+	}
 	return nil
 }
 
+// showMessages adds delta to the number of executing commands whose
+// messages are shown in the /LSP/Messages window.
+func (h *clientHandler) showMessages(delta int) {
+	h.mu.Lock()
+	h.nshow += delta
+	h.mu.Unlock()
+}
+
 func (h *clientHandler) LogMessage(ctx context.Context, params *protocol.LogMessageParams) error {
 	if h.cfg.Logger != nil {
 		h.cfg.Logger.Printf("%v: %v\n", params.Type, params.Message)
@@ -58,11 +79,81 @@ func (h *clientHandler) Event(context.Context, *interface{}) error {
 }
 
 func (h *clientHandler) PublishDiagnostics(ctx context.Context, params *protocol.PublishDiagnosticsParams) error {
+	h.mu.Lock()
+	if len(params.Diagnostics) == 0 {
+		delete(h.diag, params.URI)
+	} else {
+		h.diag[params.URI] = params.Diagnostics
+	}
+	h.mu.Unlock()
+
 	if h.hideDiag {
 		return nil
 	}
 
-	h.diagWriter.WriteDiagnostics(params)
+	h.diagWriter.WriteDiagnostics(h.cfg.diagFilter.filter(params))
+	return nil
+}
+
+// diagnostics returns the diagnostics last published by the server for uri.
+func (h *clientHandler) diagnostics(uri protocol.DocumentURI) []protocol.Diagnostic {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.diag[uri]
+}
+
+// shownDiagnostics returns the diagnostics last published by the server
+// that pass its diagnostics filter, for document uri or for all documents
+// if uri is empty, sorted by URI.
+func (h *clientHandler) shownDiagnostics(uri protocol.DocumentURI) []protocol.PublishDiagnosticsParams {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result []protocol.PublishDiagnosticsParams
+	for u, diags := range h.diag {
+		if uri != "" && u != uri {
+			continue
+		}
+		p := h.cfg.diagFilter.filter(&protocol.PublishDiagnosticsParams{
+			URI:         u,
+			Diagnostics: diags,
+		})
+		if len(p.Diagnostics) > 0 {
+			result = append(result, *p)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URI < result[j].URI
+	})
+	return result
+}
+
+func (h *clientHandler) CodeLensRefresh(context.Context) error {
+	// The server may not answer requests until we reply,
+	// so fetch the new lenses in the background.
+	go func() {
+		if err := refreshCodeLenses(context.Background(), h.client); err != nil {
+			log.Printf("code lens refresh failed: %v", err)
+		}
+	}()
+	return nil
+}
+
+func (h *clientHandler) DiagnosticRefresh(context.Context) error {
+	// The server may not answer requests until we reply,
+	// so pull the diagnostics in the background.
+	go h.client.refreshDiagnostics(context.Background())
+	return nil
+}
+
+func (h *clientHandler) InlayHintRefresh(context.Context) error {
+	// The server may not answer requests until we reply,
+	// so fetch the new hints in the background.
+	go func() {
+		if err := refreshInlayHints(context.Background(), h.client); err != nil {
+			log.Printf("inlay hints refresh failed: %v", err)
+		}
+	}()
 	return nil
 }
 
@@ -87,7 +178,7 @@ func (h *clientHandler) ShowMessageRequest(context.Context, *protocol.ShowMessag
 }
 
 func (h *clientHandler) ApplyEdit(ctx context.Context, params *protocol.ApplyWorkspaceEditParams) (*protocol.ApplyWorkspaceEditResult, error) {
-	err := editWorkspace(&params.Edit, &text.AcmeMenu{})
+	err := editWorkspace(ctx, &params.Edit, &text.AcmeMenu{}, h.client)
 	if err != nil {
 		return &protocol.ApplyWorkspaceEditResult{Applied: false, FailureReason: err.Error()}, nil
 	}
@@ -104,6 +195,7 @@ type ClientConfig struct {
 	DiagWriter    DiagnosticsWriter          // notification handler writes diagnostics here
 	Workspaces    []protocol.WorkspaceFolder // initial workspace folders
 	Logger        *log.Logger
+	diagFilter    *diagnosticsFilter // diagnostics written to DiagWriter
 }
 
 // docState holds the tracked state of an open document.
@@ -118,14 +210,20 @@ type Client struct {
 	initializeResult *protocol.InitializeResult
 	cfg              *ClientConfig
 	rpc              *jsonrpc2.Conn
+	handler          *clientHandler
 	openDocs         map[protocol.DocumentURI]docState
+	hintRanges       map[protocol.DocumentURI]protocol.Range // of the last inlay hint requests not for the whole document
+	pulls            map[protocol.DocumentURI]*pendingPull   // textDocument/diagnostic requests scheduled or in flight
+	pullingWorkspace bool                                    // a workspace/diagnostic request is in flight
 	mu               sync.Mutex
 }
 
 func NewClient(conn net.Conn, cfg *ClientConfig) (*Client, error) {
 	c := &Client{
-		cfg:      cfg,
-		openDocs: make(map[protocol.DocumentURI]docState),
+		cfg:        cfg,
+		openDocs:   make(map[protocol.DocumentURI]docState),
+		hintRanges: make(map[protocol.DocumentURI]protocol.Range),
+		pulls:      make(map[protocol.DocumentURI]*pendingPull),
 	}
 	if err := c.init(conn, cfg); err != nil {
 		return nil, err
@@ -140,12 +238,15 @@ func (c *Client) Close() error {
 func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 	ctx := context.Background()
 	stream := jsonrpc2.NewBufferedStream(conn, jsonrpc2.VSCodeObjectCodec{})
-	handler := proxy.NewClientHandler(&clientHandler{
+	ch := &clientHandler{
 		cfg:        cfg,
 		hideDiag:   cfg.HideDiag,
 		diagWriter: cfg.DiagWriter,
 		diag:       make(map[protocol.DocumentURI][]protocol.Diagnostic),
-	})
+		resultIDs:  make(map[protocol.DocumentURI]string),
+		client:     c,
+	}
+	handler := proxy.NewClientHandler(ch)
 	var opts []jsonrpc2.ConnOpt
 	if cfg.RPCTrace {
 		opts = append(opts, lsp.LogMessages(log.Default()))
@@ -160,6 +261,7 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 		log.Printf("jsonrpc2 client connection to LSP sever disconnected\n")
 	}()
 	c.rpc = rpc
+	c.handler = ch
 
 	d, err := filepath.Abs(cfg.RootDirectory)
 	if err != nil {
@@ -174,21 +276,50 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 						CodeActionLiteralSupport: protocol.ClientCodeActionLiteralOptions{
 							CodeActionKind: protocol.ClientCodeActionKindOptions{
 								ValueSet: []protocol.CodeActionKind{
+									protocol.QuickFix,
+									protocol.Refactor,
+									protocol.RefactorExtract,
+									protocol.RefactorInline,
+									protocol.RefactorRewrite,
+									protocol.Source,
 									protocol.SourceOrganizeImports,
+									protocol.SourceFixAll,
 								},
 							},
 						},
 					},
+					Diagnostic: &protocol.DiagnosticClientCapabilities{
+						RelatedDocumentSupport: true,
+					},
 					DocumentSymbol: protocol.DocumentSymbolClientCapabilities{
 						HierarchicalDocumentSymbolSupport: true,
 					},
 					Completion: protocol.CompletionClientCapabilities{
 						CompletionItem: protocol.ClientCompletionItemOptions{
+							SnippetSupport:       true,
+							InsertReplaceSupport: true,
+							ResolveSupport: &protocol.ClientCompletionItemResolveOptions{
+								Properties: []string{"documentation", "detail", "additionalTextEdits"},
+							},
 							TagSupport: &protocol.CompletionItemTagOptions{
 								ValueSet: []protocol.CompletionItemTag{},
 							},
 						},
 					},
+					InlayHint: &protocol.InlayHintClientCapabilities{},
+					RangeFormatting: &protocol.DocumentRangeFormattingClientCapabilities{
+						RangesSupport: true,
+					},
+					SignatureHelp: &protocol.SignatureHelpClientCapabilities{
+						ContextSupport: true,
+						SignatureInformation: &protocol.ClientSignatureInformationOptions{
+							DocumentationFormat: []protocol.MarkupKind{
+								protocol.Markdown,
+								protocol.PlainText,
+							},
+							ActiveParameterSupport: true,
+						},
+					},
 					SemanticTokens: protocol.SemanticTokensClientCapabilities{
 						Formats:        []protocol.TokenFormat{},
 						TokenModifiers: []string{},
@@ -198,6 +329,36 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 				Workspace: protocol.WorkspaceClientCapabilities{
 					WorkspaceFolders: true,
 					ApplyEdit:        true,
+					WorkspaceEdit: &protocol.WorkspaceEditClientCapabilities{
+						DocumentChanges: true,
+						ResourceOperations: []protocol.ResourceOperationKind{
+							protocol.Create,
+							protocol.Rename,
+							protocol.Delete,
+						},
+					},
+					FileOperations: &protocol.FileOperationClientCapabilities{
+						DidCreate: true,
+						DidRename: true,
+						DidDelete: true,
+					},
+					Symbol: &protocol.WorkspaceSymbolClientCapabilities{
+						SymbolKind: &protocol.ClientSymbolKindOptions{
+							ValueSet: symbolKinds(),
+						},
+						ResolveSupport: &protocol.ClientSymbolResolveOptions{
+							Properties: []string{"location.range"},
+						},
+					},
+					CodeLens: &protocol.CodeLensWorkspaceClientCapabilities{
+						RefreshSupport: true,
+					},
+					Diagnostics: &protocol.DiagnosticWorkspaceClientCapabilities{
+						RefreshSupport: true,
+					},
+					InlayHint: &protocol.InlayHintWorkspaceClientCapabilities{
+						RefreshSupport: true,
+					},
 				},
 			},
 			InitializationOptions: cfg.Options,
@@ -216,6 +377,7 @@ func (c *Client) init(conn net.Conn, cfg *ClientConfig) error {
 	}
 	c.Server = server
 	c.initializeResult = result
+	go c.pullWorkspaceDiagnostics(context.Background())
 	return nil
 }
 
@@ -234,11 +396,129 @@ func (c *Client) WorkspaceFolders(context.Context) ([]protocol.WorkspaceFolder,
 	panic("intentionally not implemented")
 }
 
+// WorkspaceSymbols implements proxy.Server. The server key is ignored.
+func (s *Client) WorkspaceSymbols(ctx context.Context, params *proxy.WorkspaceSymbolsParams) ([]protocol.SymbolInformation, error) {
+	symbols, err := s.workspaceSymbols(ctx, &params.WorkspaceSymbolParams)
+	if err != nil {
+		return nil, err
+	}
+	return resolveWorkspaceSymbols(ctx, selectWorkspaceSymbols(params, symbols))
+}
+
+// InlayHint implements protocol.Server. The range of the request is
+// remembered, unless it covers the whole document, so that the hints
+// can be refreshed for the same range.
+func (s *Client) InlayHint(ctx context.Context, params *protocol.InlayHintParams) ([]protocol.InlayHint, error) {
+	uri := params.TextDocument.URI
+	s.mu.Lock()
+	state, ok := s.openDocs[uri]
+	if ok && params.Range.Start == (protocol.Position{}) && params.Range.End == state.end {
+		delete(s.hintRanges, uri)
+	} else {
+		s.hintRanges[uri] = params.Range
+	}
+	s.mu.Unlock()
+	return s.Server.InlayHint(ctx, params)
+}
+
+// inlayHintRange returns the range of the last inlay hint request for
+// document uri, or the range of the whole document body.
+func (s *Client) inlayHintRange(uri protocol.DocumentURI, body string) protocol.Range {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rng, ok := s.hintRanges[uri]; ok {
+		return rng
+	}
+	return wholeFileRange(body)
+}
+
 // ExecuteCommandOnDocument implements proxy.Server.
 func (s *Client) ExecuteCommandOnDocument(ctx context.Context, params *proxy.ExecuteCommandOnDocumentParams) (interface{}, error) {
+	if params.ShowMessages && s.handler != nil {
+		s.handler.showMessages(1)
+		defer s.handler.showMessages(-1)
+	}
+	return s.Server.ExecuteCommand(ctx, &params.ExecuteCommandParams)
+}
+
+// ExecuteCommandOnServer implements proxy.Server. The server key is ignored.
+func (s *Client) ExecuteCommandOnServer(ctx context.Context, params *proxy.ExecuteCommandOnServerParams) (interface{}, error) {
 	return s.Server.ExecuteCommand(ctx, &params.ExecuteCommandParams)
 }
 
+// ResolveCompletionItemOnDocument implements proxy.Server.
+func (s *Client) ResolveCompletionItemOnDocument(ctx context.Context, params *proxy.ResolveCompletionItemOnDocumentParams) (*protocol.CompletionItem, error) {
+	return s.Server.ResolveCompletionItem(ctx, &params.CompletionItem)
+}
+
+// Commands implements proxy.Server.
+func (s *Client) Commands(context.Context) ([]proxy.ServerCommands, error) {
+	var key string
+	if s.cfg != nil && s.cfg.FilenameHandler != nil {
+		key = s.cfg.FilenameHandler.ServerKey
+	}
+	return []proxy.ServerCommands{{
+		ServerKey: key,
+		Commands:  lsp.ServerCommands(&s.initializeResult.Capabilities),
+	}}, nil
+}
+
+// Diagnostics implements proxy.Server.
+func (s *Client) Diagnostics(ctx context.Context, params *proxy.DiagnosticsParams) ([]protocol.PublishDiagnosticsParams, error) {
+	if s.handler == nil {
+		return nil, nil
+	}
+	return s.handler.shownDiagnostics(params.TextDocument.URI), nil
+}
+
+// SignatureHelp implements protocol.Server. See decodeSignatureHelp.
+func (s *Client) SignatureHelp(ctx context.Context, params *protocol.SignatureHelpParams) (*protocol.SignatureHelp, error) {
+	var result json.RawMessage
+	if err := s.rpc.Call(ctx, "textDocument/signatureHelp", params, &result); err != nil {
+		return nil, err
+	}
+	return decodeSignatureHelp(result)
+}
+
+// CodeAction implements proxy.Server. If the request doesn't carry any
+// diagnostics, the ones last published by the server that overlap the
+// requested range are included so that quick fixes can be offered,
+// unless the request is only for kinds other than quick fixes.
+func (s *Client) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	if len(params.Context.Diagnostics) == 0 && s.handler != nil && wantsQuickFix(params.Context.Only) {
+		params.Context.Diagnostics = overlappingDiagnostics(s.handler.diagnostics(params.TextDocument.URI), params.Range)
+	}
+	return s.Server.CodeAction(ctx, params)
+}
+
+// wantsQuickFix reports whether code actions of kinds include quick
+// fixes, which are computed from the diagnostics in the request
+// context. No kinds means any kind.
+func wantsQuickFix(kinds []protocol.CodeActionKind) bool {
+	if len(kinds) == 0 {
+		return true
+	}
+	for _, k := range kinds {
+		if k == protocol.QuickFix || strings.HasPrefix(string(k), string(protocol.QuickFix)+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// overlappingDiagnostics returns the diagnostics whose range overlaps rng.
+func overlappingDiagnostics(diags []protocol.Diagnostic, rng protocol.Range) []protocol.Diagnostic {
+	out := []protocol.Diagnostic{}
+	for _, d := range diags {
+		if lsp.ComparePositions(d.Range.End, rng.Start) < 0 || lsp.ComparePositions(rng.End, d.Range.Start) < 0 {
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
 func (s *Client) didOpen(ctx context.Context, params *proxy.SyncDocumentParams) error {
 	var langID protocol.LanguageKind
 	if s.cfg != nil && s.cfg.FilenameHandler != nil {
@@ -274,6 +554,7 @@ func (s *Client) SyncDocument(ctx context.Context, params *proxy.SyncDocumentPar
 			return err
 		}
 		s.openDocs[params.TextDocument.URI] = docState{version: 1, end: newEnd}
+		s.schedulePull(params.TextDocument.URI)
 		return nil
 	}
 
@@ -304,9 +585,18 @@ func (s *Client) SyncDocument(ctx context.Context, params *proxy.SyncDocumentPar
 		return err
 	}
 	s.openDocs[params.TextDocument.URI] = docState{version: newVersion, end: newEnd}
+	s.schedulePull(params.TextDocument.URI)
 	return nil
 }
 
+// isOpen reports whether the document uri has been opened with SyncDocument.
+func (s *Client) isOpen(uri protocol.DocumentURI) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.openDocs[uri]
+	return ok
+}
+
 func (s *Client) DidClose(ctx context.Context, params *protocol.DidCloseTextDocumentParams) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -318,8 +608,26 @@ func (s *Client) DidClose(ctx context.Context, params *protocol.DidCloseTextDocu
 		return err
 	}
 	delete(s.openDocs, params.TextDocument.URI)
+	s.cancelPull(params.TextDocument.URI)
 	return nil
 }
+
+// DidSave implements protocol.Server. Saving a file may affect the
+// diagnostics of other files, so the workspace diagnostics are pulled
+// again along with the document's.
+func (s *Client) DidSave(ctx context.Context, params *protocol.DidSaveTextDocumentParams) error {
+	if err := s.Server.DidSave(ctx, params); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if _, ok := s.openDocs[params.TextDocument.URI]; ok {
+		s.schedulePull(params.TextDocument.URI)
+	}
+	s.mu.Unlock()
+	go s.pullWorkspaceDiagnostics(context.Background())
+	return nil
+}
+
 func (s *Client) DidChange(context.Context, *protocol.DidChangeTextDocumentParams) error {
 	return fmt.Errorf("not implemented -- use SyncDocument")
 }
@@ -455,3 +455,50 @@ func TestClientProvidesCodeAction(t *testing.T) {
 		}
 	}
 }
+
+func TestWantsQuickFix(t *testing.T) {
+	for _, tc := range []struct {
+		kinds []protocol.CodeActionKind
+		want  bool
+	}{
+		{nil, true},
+		{[]protocol.CodeActionKind{protocol.SourceOrganizeImports}, false},
+		{[]protocol.CodeActionKind{protocol.SourceOrganizeImports, protocol.QuickFix}, true},
+		{[]protocol.CodeActionKind{"quickfix.gopls"}, true},
+		{[]protocol.CodeActionKind{"quickfixes"}, false},
+		{[]protocol.CodeActionKind{protocol.RefactorRewrite}, false},
+	} {
+		if got := wantsQuickFix(tc.kinds); got != tc.want {
+			t.Errorf("wantsQuickFix(%v) is %v; want %v", tc.kinds, got, tc.want)
+		}
+	}
+}
+
+func TestOverlappingDiagnostics(t *testing.T) {
+	pos := func(line, col uint32) protocol.Position {
+		return protocol.Position{Line: line, Character: col}
+	}
+	diags := []protocol.Diagnostic{
+		{Range: protocol.Range{Start: pos(1, 0), End: pos(1, 5)}, Message: "a"},
+		{Range: protocol.Range{Start: pos(2, 3), End: pos(4, 1)}, Message: "b"},
+		{Range: protocol.Range{Start: pos(6, 0), End: pos(6, 0)}, Message: "c"},
+	}
+	for _, tc := range []struct {
+		rng  protocol.Range
+		want []string
+	}{
+		{protocol.Range{Start: pos(0, 0), End: pos(0, 10)}, nil},
+		{protocol.Range{Start: pos(1, 5), End: pos(1, 5)}, []string{"a"}},
+		{protocol.Range{Start: pos(1, 2), End: pos(3, 0)}, []string{"a", "b"}},
+		{protocol.Range{Start: pos(4, 2), End: pos(5, 0)}, nil},
+		{protocol.Range{Start: pos(6, 0), End: pos(6, 0)}, []string{"c"}},
+	} {
+		var got []string
+		for _, d := range overlappingDiagnostics(diags, tc.rng) {
+			got = append(got, d.Message)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("diagnostics overlapping %v are %v; want %v", tc.rng, got, tc.want)
+		}
+	}
+}
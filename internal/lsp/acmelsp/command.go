@@ -0,0 +1,190 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// Commands prints the commands supported by each server, one per line
+// preceded by the key of the server in the configuration.
+func Commands(ctx context.Context, w io.Writer, server proxy.Server) error {
+	cmds, err := server.Commands(ctx)
+	if err != nil {
+		return err
+	}
+	for _, sc := range cmds {
+		for _, c := range sc.Commands {
+			fmt.Fprintf(w, "%v\t%v\n", sc.ServerKey, c)
+		}
+	}
+	return nil
+}
+
+// Execute executes command with the JSON arguments args and prints the
+// result. If serverKey is not empty, the command is executed by the server
+// with that key. Otherwise, it's executed by the server handling the
+// document in win if it supports the command, or by any server supporting
+// it. The placeholders ${uri}, ${position} and ${range} within args are
+// replaced by the URI of the document in win and the position and range
+// of its current selection. Win may be nil if args contain no placeholders.
+func Execute(ctx context.Context, w io.Writer, server proxy.Server, win text.AddressableFile, serverKey, command string, args []string) error {
+	vars := func() (map[string]interface{}, error) {
+		if win == nil {
+			return nil, fmt.Errorf("no window to substitute placeholders")
+		}
+		return placeholderValues(win)
+	}
+	jargs := []json.RawMessage{}
+	for _, arg := range args {
+		arg, err := substituteArgs(arg, vars)
+		if err != nil {
+			return err
+		}
+		var r json.RawMessage
+		err = json.NewDecoder(strings.NewReader(arg)).Decode(&r)
+		if err != nil {
+			return fmt.Errorf("could not parse argument %v: %v", arg, err)
+		}
+		jargs = append(jargs, r)
+	}
+	params := protocol.ExecuteCommandParams{
+		Command:   command,
+		Arguments: jargs,
+	}
+
+	var (
+		resp interface{}
+		err  error
+	)
+	switch {
+	case serverKey != "":
+		resp, err = server.ExecuteCommandOnServer(ctx, &proxy.ExecuteCommandOnServerParams{
+			ServerKey:            serverKey,
+			ExecuteCommandParams: params,
+		})
+	case win != nil && documentSupportsCommand(ctx, server, win, command):
+		uri, _, _ := text.DocumentURI(win)
+		resp, err = server.ExecuteCommandOnDocument(ctx, &proxy.ExecuteCommandOnDocumentParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: uri,
+			},
+			ExecuteCommandParams: params,
+		})
+	default:
+		resp, err = server.ExecuteCommand(ctx, &params)
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// documentSupportsCommand returns true if the server handling the
+// document in win supports command.
+func documentSupportsCommand(ctx context.Context, server proxy.Server, win text.AddressableFile, command string) bool {
+	uri, _, err := text.DocumentURI(win)
+	if err != nil {
+		return false
+	}
+	initres, err := server.InitializeResult(ctx, &protocol.TextDocumentIdentifier{
+		URI: uri,
+	})
+	if err != nil {
+		return false
+	}
+	return containsString(lsp.ServerCommands(&initres.Capabilities), command)
+}
+
+// placeholderValues returns the values of the placeholders that can be
+// used in the arguments of a command, for the document in win.
+func placeholderValues(win text.AddressableFile) (map[string]interface{}, error) {
+	loc, _, err := text.Selection(win)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"uri":      loc.URI,
+		"position": loc.Range.Start,
+		"range":    loc.Range,
+	}, nil
+}
+
+var (
+	placeholderRegexp       = regexp.MustCompile(`^\$\{(\w+)\}`)
+	quotedPlaceholderRegexp = regexp.MustCompile(`^"\$\{(\w+)\}"`)
+)
+
+// substituteArgs replaces the placeholders ${name} within the JSON
+// argument arg by the JSON encoding of their value. A placeholder that
+// makes up a whole JSON string is replaced along with the quotes, so
+// that "${uri}" and ${uri} are equivalent. A placeholder within a
+// larger JSON string, as in "file ${uri}", is replaced by its escaped
+// value, which must be a string. Vars is only called if arg contains a
+// placeholder.
+func substituteArgs(arg string, vars func() (map[string]interface{}, error)) (string, error) {
+	var values map[string]interface{}
+	value := func(name string) ([]byte, error) {
+		if values == nil {
+			var err error
+			if values, err = vars(); err != nil {
+				return nil, err
+			}
+		}
+		v, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder ${%v}", name)
+		}
+		return json.Marshal(v)
+	}
+
+	var b strings.Builder
+	inString := false
+	for i := 0; i < len(arg); {
+		if !inString {
+			if m := quotedPlaceholderRegexp.FindStringSubmatch(arg[i:]); m != nil {
+				v, err := value(m[1])
+				if err != nil {
+					return "", err
+				}
+				b.Write(v)
+				i += len(m[0])
+				continue
+			}
+		}
+		if m := placeholderRegexp.FindStringSubmatch(arg[i:]); m != nil {
+			v, err := value(m[1])
+			if err != nil {
+				return "", err
+			}
+			if inString {
+				if len(v) < 2 || v[0] != '"' {
+					return "", fmt.Errorf("placeholder ${%v} within a string is not a string", m[1])
+				}
+				v = v[1 : len(v)-1]
+			}
+			b.Write(v)
+			i += len(m[0])
+			continue
+		}
+		switch c := arg[i]; {
+		case c == '"':
+			inString = !inString
+		case c == '\\' && inString && i+1 < len(arg):
+			b.WriteString(arg[i : i+2])
+			i += 2
+			continue
+		}
+		b.WriteByte(arg[i])
+		i++
+	}
+	return b.String(), nil
+}
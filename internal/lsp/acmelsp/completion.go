@@ -0,0 +1,365 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"unicode"
+
+	"9fans.net/acme-lsp/internal/acmeutil"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// completionEdits returns the edits that insert the completion item into
+// body, where the cursor is at rune offset q, and the rune range
+// [q0, q1) to select once the edits are applied. For snippets, that's
+// the first placeholder so that the user can type over it. The edits
+// include the additional edits of the item (e.g. an import of the
+// package of the completed identifier).
+func completionEdits(item *protocol.CompletionItem, body []rune, q int) (edits []protocol.TextEdit, q0, q1 int) {
+	off, _ := text.GetNewlineOffsets(strings.NewReader(string(body)))
+	position := func(q int) protocol.Position {
+		line, col := off.OffsetToLine(q)
+		return protocol.Position{Line: uint32(line), Character: uint32(col)}
+	}
+
+	var edit protocol.TextEdit
+	ok := false
+	if item.TextEdit != nil {
+		switch e := item.TextEdit.Value.(type) {
+		case protocol.TextEdit:
+			edit, ok = e, true
+		case protocol.InsertReplaceEdit:
+			// Don't replace the text following the cursor.
+			edit = protocol.TextEdit{Range: e.Insert, NewText: e.NewText}
+			ok = true
+		}
+	}
+	if !ok {
+		// Without an edit, the text replaces the word before the cursor.
+		start := q
+		for start > 0 && isIdentRune(body[start-1]) {
+			start--
+		}
+		edit = protocol.TextEdit{
+			Range:   protocol.Range{Start: position(start), End: position(q)},
+			NewText: item.InsertText,
+		}
+		if edit.NewText == "" {
+			edit.NewText = item.Label
+		}
+	}
+
+	offset := func(p protocol.Position) int {
+		return off.LineToOffset(int(p.Line), int(p.Character))
+	}
+	start := offset(edit.Range.Start)
+	if item.InsertTextFormat != nil && *item.InsertTextFormat == protocol.SnippetTextFormat {
+		edit.NewText, q0, q1 = text.ExpandSnippet(edit.NewText)
+	} else {
+		q0 = len([]rune(edit.NewText))
+		q1 = q0
+	}
+	// Additional edits before the completion move it.
+	for _, e := range item.AdditionalTextEdits {
+		if s := offset(e.Range.Start); s < start {
+			start += len([]rune(e.NewText)) - (offset(e.Range.End) - s)
+		}
+	}
+	edits = append([]protocol.TextEdit{edit}, item.AdditionalTextEdits...)
+	return edits, start + q0, start + q1
+}
+
+// resolveCompletionItem returns item with the properties that the server
+// computes lazily, such as documentation and additional edits, filled in.
+// The item is returned unchanged if the server can't resolve it.
+func resolveCompletionItem(ctx context.Context, server proxy.Server, doc *protocol.TextDocumentIdentifier, item *protocol.CompletionItem) *protocol.CompletionItem {
+	initres, err := server.InitializeResult(ctx, doc)
+	if err != nil {
+		log.Printf("failed to resolve completion item: %v", err)
+		return item
+	}
+	if cp := initres.Capabilities.CompletionProvider; cp == nil || !cp.ResolveProvider {
+		return item
+	}
+	resolved, err := server.ResolveCompletionItemOnDocument(ctx, &proxy.ResolveCompletionItemOnDocumentParams{
+		TextDocument:   *doc,
+		CompletionItem: *item,
+	})
+	if err != nil {
+		log.Printf("failed to resolve completion item: %v", err)
+		return item
+	}
+	return resolved
+}
+
+// completionDocumentation returns the documentation of the completion
+// item as plain text.
+func completionDocumentation(item *protocol.CompletionItem) string {
+	if item.Documentation == nil {
+		return ""
+	}
+	return text.Documentation(item.Documentation.Value)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// completionWinName is the name of the acme window listing the
+// candidates of the last completion.
+const completionWinName = "/LSP/Completions"
+
+// completionInserter inserts completion candidates into the window
+// where completion was requested, at the cursor position at that time.
+type completionInserter struct {
+	server proxy.Server
+	win    text.AddressableFile
+	doc    protocol.TextDocumentIdentifier
+	body   []rune // body of win when completion was requested
+	q      int    // cursor position in body
+	want   []rune // body of win after the last insertion, if any
+}
+
+func newCompletionInserter(server proxy.Server, win text.AddressableFile, doc *protocol.TextDocumentIdentifier) (*completionInserter, error) {
+	body, err := readBody(win)
+	if err != nil {
+		return nil, err
+	}
+	q, _, err := win.CurrentAddr()
+	if err != nil {
+		return nil, err
+	}
+	return &completionInserter{
+		server: server,
+		win:    win,
+		doc:    *doc,
+		body:   body,
+		q:      q,
+	}, nil
+}
+
+// insert inserts the completion item, replacing the one inserted
+// before, if any. It returns the documentation of the item.
+func (ci *completionInserter) insert(ctx context.Context, item *protocol.CompletionItem) (string, error) {
+	if ci.want != nil {
+		if err := ci.revert(); err != nil {
+			return "", err
+		}
+	}
+	item = resolveCompletionItem(ctx, ci.server, &ci.doc, item)
+	edits, q0, q1 := completionEdits(item, ci.body, ci.q)
+	if err := text.Edit(ci.win, edits); err != nil {
+		return "", fmt.Errorf("failed to apply completion edit: %v", err)
+	}
+	if err := ci.win.SetCurrentAddr(q0, q1); err != nil {
+		return "", err
+	}
+	want, err := readBody(ci.win)
+	if err != nil {
+		return "", err
+	}
+	ci.want = want
+	return completionDocumentation(item), nil
+}
+
+// revert restores the body of the window to what it was before the
+// last insertion.
+func (ci *completionInserter) revert() error {
+	cur, err := readBody(ci.win)
+	if err != nil {
+		return err
+	}
+	if string(cur) != string(ci.want) {
+		return fmt.Errorf("%v changed since completion was requested", text.ToPath(ci.doc.URI))
+	}
+	p, s := commonAffixes(cur, ci.body)
+	_, err = ci.win.WriteAt(p, len(cur)-s, []byte(string(ci.body[p:len(ci.body)-s])))
+	return err
+}
+
+// completionWin is the window listing completion candidates. Looking
+// (button 3) or executing (button 2) anywhere on the line of a
+// candidate inserts it in place of the candidate inserted before.
+// Executing Filter with an argument lists only the candidates
+// matching it; without an argument, all candidates are listed again.
+type completionWin struct {
+	*acmeutil.Win
+	ins    *completionInserter
+	items  []protocol.CompletionItem
+	shown  []int  // indexes in items of the listed candidates
+	filter string // fuzzy prefix of the listed candidates
+	doc    string // documentation of the inserted candidate
+}
+
+// windows counts the goroutines serving acme windows, such as
+// /LSP/Completions, until they're deleted.
+var windows sync.WaitGroup
+
+// WaitWindows waits until the acme windows served by this process,
+// such as /LSP/Completions, are deleted.
+func WaitWindows() {
+	windows.Wait()
+}
+
+// runCompletionWin shows the completion candidates items in the
+// /LSP/Completions window and returns. The candidates chosen by the
+// user are inserted in the background until the window is deleted. The
+// window of a previous completion is deleted, which makes the process
+// serving it stop.
+func runCompletionWin(ctx context.Context, ins *completionInserter, items []protocol.CompletionItem, doc string) error {
+	if err := deleteWin(completionWinName); err != nil {
+		return err
+	}
+	w, err := acmeutil.NewWin()
+	if err != nil {
+		return err
+	}
+	w.Name(completionWinName)
+	w.Write("tag", []byte("Filter "))
+
+	// The files of the window where completion was requested are
+	// opened again when needed. Keeping them open while we wait would
+	// prevent acme from reporting its deletion in acme/log.
+	ins.win.CloseFiles()
+
+	cw := &completionWin{
+		Win:   w,
+		ins:   ins,
+		items: items,
+		doc:   doc,
+	}
+	cw.show()
+
+	windows.Add(1)
+	go func() {
+		defer func() {
+			w.Del(true)
+			w.CloseFiles()
+			windows.Done()
+		}()
+
+		if err := cw.run(ctx); err != nil {
+			log.Printf("completion window: %v", err)
+		}
+	}()
+	return nil
+}
+
+// run handles the events of the window until it's deleted.
+func (cw *completionWin) run(ctx context.Context) error {
+	w, ins, items := cw.Win, cw.ins, cw.items
+	for ev := range w.EventChan() {
+		if ev == nil {
+			break
+		}
+		switch ev.C2 {
+		case 'X', 'L': // execute or look in body
+			b, err := w.ReadAll("body")
+			if err != nil {
+				return err
+			}
+			if i := lineIndex([]rune(string(b)), ev.Q0); i < len(cw.shown) {
+				doc, err := ins.insert(ctx, &items[cw.shown[i]])
+				ins.win.CloseFiles()
+				if err != nil {
+					w.Errf("%v", err)
+					continue
+				}
+				cw.doc = doc
+				cw.show()
+				continue
+			}
+		case 'x': // execute in tag
+			f := strings.Fields(string(ev.Text))
+			if len(f) > 0 && f[0] == "Del" {
+				return nil
+			}
+			if len(f) > 0 && f[0] == "Filter" {
+				cw.filter = strings.Join(f[1:], " ")
+				if cw.filter == "" {
+					cw.filter = strings.TrimSpace(string(ev.Arg))
+				}
+				cw.show()
+				continue
+			}
+		}
+		w.WriteEvent(ev)
+	}
+	return nil
+}
+
+// show lists the candidates matching the filter, followed by the
+// documentation of the inserted candidate.
+func (cw *completionWin) show() {
+	cw.shown = cw.shown[:0]
+	var sb strings.Builder
+	for i, item := range cw.items {
+		name := item.FilterText
+		if name == "" {
+			name = item.Label
+		}
+		if !fuzzyPrefixMatch(cw.filter, name) {
+			continue
+		}
+		cw.shown = append(cw.shown, i)
+		fmt.Fprintf(&sb, "%v\t%v\n", item.Label, item.Detail)
+	}
+	cw.Clear()
+	cw.PrintTabbed(sb.String())
+	if cw.doc != "" {
+		cw.Write("body", []byte("\n"+cw.doc))
+	}
+	cw.Ctl("clean")
+}
+
+// fuzzyPrefixMatch reports whether s starts with the first rune of
+// pattern and contains the rest of its runes in order, ignoring case.
+func fuzzyPrefixMatch(pattern, s string) bool {
+	p := []rune(strings.ToLower(pattern))
+	if len(p) == 0 {
+		return true
+	}
+	for i, r := range strings.ToLower(s) {
+		if i == 0 && r != p[0] {
+			return false
+		}
+		if r == p[0] {
+			p = p[1:]
+			if len(p) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commonAffixes returns the lengths of the longest common prefix and,
+// in the rest, of the longest common suffix of a and b.
+func commonAffixes(a, b []rune) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// readBody returns the body of window w.
+func readBody(w text.File) ([]rune, error) {
+	r, err := w.Reader()
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return []rune(string(b)), nil
+}
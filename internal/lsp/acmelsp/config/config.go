@@ -46,6 +46,9 @@ type File struct {
 	// Don't show diagnostics sent by the LSP server.
 	HideDiagnostics bool
 
+	// Diagnostics shown in the /LSP/Diagnostics window.
+	DiagnosticsFilter DiagnosticsFilter
+
 	// Format file when Put is executed in a window.
 	FormatOnPut bool
 
@@ -103,6 +106,31 @@ type Server struct {
 
 	// FormattingOptions are passed on Format
 	FormattingOptions protocol.FormattingOptions
+
+	// DiagnosticsFilter selects the diagnostics of this server shown in
+	// the /LSP/Diagnostics window, instead of File.DiagnosticsFilter.
+	DiagnosticsFilter *DiagnosticsFilter
+}
+
+// DiagnosticsFilter selects the diagnostics shown in the /LSP/Diagnostics
+// window. A diagnostic is shown if it passes all the filters that are set.
+type DiagnosticsFilter struct {
+	// Minimum severity of the diagnostics shown: "error", "warning",
+	// "information" or "hint".
+	MinSeverity string
+
+	// Sources of the diagnostics shown (e.g. "compiler").
+	IncludeSources []string
+
+	// Sources of the diagnostics hidden (e.g. "simplifycompositelit").
+	ExcludeSources []string
+
+	// Regular expression matching the messages of the diagnostics hidden.
+	ExcludeMessage string
+
+	// Regular expression matching the paths of the files whose
+	// diagnostics are hidden.
+	ExcludePath string
 }
 
 // FilenameHandler contains a regular expression pattern that matches a filename
@@ -200,21 +228,21 @@ func Load() (*Config, error) {
 		}
 		s := cfg.File.Servers[key]
 		var err error
-		if s.StderrFile, err = cacheFilePath(s.StderrFile); err != nil {
+		if s.StderrFile, err = CacheFilePath(s.StderrFile); err != nil {
 			return nil, err
 		}
-		if s.LogFile, err = cacheFilePath(s.LogFile); err != nil {
+		if s.LogFile, err = CacheFilePath(s.LogFile); err != nil {
 			return nil, err
 		}
 	}
 	return cfg, nil
 }
 
-// cacheFilePath returns an absolute path for the given log file path.
+// CacheFilePath returns an absolute path for the given file path.
 // If path is empty or already absolute, it is returned unchanged.
 // Otherwise, it is resolved relative to the acme-lsp user cache directory,
 // which is created if it does not exist.
-func cacheFilePath(path string) (string, error) {
+func CacheFilePath(path string) (string, error) {
 	if path == "" || filepath.IsAbs(path) {
 		return path, nil
 	}
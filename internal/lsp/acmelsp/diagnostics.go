@@ -1,15 +1,23 @@
 package acmelsp
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"9fans.net/acme-lsp/internal/acmeutil"
 	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/acmelsp/config"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
 	"9fans.net/internal/go-lsp/lsp/protocol"
 )
 
@@ -22,8 +30,21 @@ type diagWin struct {
 	paramsChan chan *protocol.PublishDiagnosticsParams
 	updateChan chan struct{}
 
-	dead bool // window has been closed
-	mu   sync.Mutex
+	dead        bool                        // window has been closed
+	folders     []string                    // workspace directories
+	minSeverity protocol.DiagnosticSeverity // of the diagnostics shown, or zero for all
+	lines       []uriDiagnostic             // diagnostic shown on each line
+	ss          *ServerSet                  // servers asked for quick fixes
+	fm          *AcmeFileManager            // shows diagnostic counts in window tags
+	mu          sync.Mutex
+}
+
+// viewSeverity maps the commands in the tag of the diagnostics window
+// to the minimum severity of the diagnostics they show.
+var viewSeverity = map[string]protocol.DiagnosticSeverity{
+	"Errors":   protocol.SeverityError,
+	"Warnings": protocol.SeverityWarning,
+	"All":      0,
 }
 
 func newDiagWin(name string) *diagWin {
@@ -49,7 +70,7 @@ func (dw *diagWin) restart() error {
 			return err
 		}
 		w.Name(dw.name)
-		w.Write("tag", []byte("Reload "))
+		w.Write("tag", []byte("Reload Fix Errors Warnings All "))
 	}
 	dw.Win = w
 	dw.dead = false
@@ -76,9 +97,26 @@ func (dw *diagWin) restart() error {
 					dw.updateChan <- struct{}{}
 					continue
 
+				case "Errors", "Warnings", "All":
+					dw.mu.Lock()
+					dw.minSeverity = viewSeverity[string(ev.Text)]
+					dw.mu.Unlock()
+					dw.updateChan <- struct{}{}
+					continue
+
+				case "Fix":
+					if q0, _, err := dw.CurrentAddr(); err == nil {
+						go dw.quickFix(q0)
+					}
+					continue
+
 				case "Restart":
 					restart()
 				}
+				if ev.C2 == 'X' { // execute in body
+					go dw.quickFix(ev.Q0)
+					continue
+				}
 			}
 			dw.WriteEvent(ev)
 		}
@@ -91,19 +129,379 @@ func (dw *diagWin) update(diags map[protocol.DocumentURI][]protocol.Diagnostic)
 		return err
 	}
 
+	dw.mu.Lock()
+	minSeverity := dw.minSeverity
+	dw.mu.Unlock()
+
 	dw.Clear()
 	body := dw.FileReadWriter("body")
-	basedir := "" // TODO
-	for uri, uriDiag := range diags {
-		for _, diag := range uriDiag {
-			loc := &protocol.Location{
-				URI:   uri,
-				Range: diag.Range,
-			}
-			fmt.Fprintf(body, "%v: %v\n", lsp.LocationLink(loc, basedir), diag.Message)
+	lines := writeDiagnostics(body, filterSeverity(diags, minSeverity), dw.workspaceFolders())
+	dw.mu.Lock()
+	dw.lines = lines
+	dw.mu.Unlock()
+	return dw.Ctl("clean")
+}
+
+// setServerSet sets the servers asked for quick fixes.
+func (dw *diagWin) setServerSet(ss *ServerSet) {
+	dw.mu.Lock()
+	dw.ss = ss
+	dw.mu.Unlock()
+}
+
+// setFileManager sets the file manager showing the number of diagnostics
+// of each file in the tag of its window.
+func (dw *diagWin) setFileManager(fm *AcmeFileManager) {
+	dw.mu.Lock()
+	dw.fm = fm
+	dw.mu.Unlock()
+}
+
+// updateTags updates the number of diagnostics shown in window tags.
+func (dw *diagWin) updateTags(diags map[protocol.DocumentURI][]protocol.Diagnostic) {
+	dw.mu.Lock()
+	fm := dw.fm
+	dw.mu.Unlock()
+
+	if fm != nil {
+		fm.setDiagnostics(diags)
+	}
+}
+
+// flushTags writes the numbers of diagnostics that couldn't be written
+// in window tags earlier.
+func (dw *diagWin) flushTags() {
+	dw.mu.Lock()
+	fm := dw.fm
+	dw.mu.Unlock()
+
+	if fm != nil {
+		fm.flushTags()
+	}
+}
+
+// quickFix applies a quick fix for the diagnostic shown on the line of
+// the window at rune offset q0. Errors are shown in the +Errors window.
+func (dw *diagWin) quickFix(q0 int) {
+	b, err := dw.ReadAll("body")
+	if err != nil {
+		dw.Errf("%v", err)
+		return
+	}
+	dw.mu.Lock()
+	lines, ss := dw.lines, dw.ss
+	dw.mu.Unlock()
+
+	i := lineIndex([]rune(string(b)), q0)
+	if i >= len(lines) || ss == nil {
+		return
+	}
+	if err := applyQuickFix(context.Background(), ss, lines[i], &text.AcmeMenu{}); err != nil {
+		dw.Errf("%v", err)
+	}
+}
+
+// applyQuickFix asks the server handling the document of diagnostic d
+// for the quick fixes of d. A single quick fix is applied; otherwise, the
+// user chooses the one applied in an acme window named /LSP/QuickFixes.
+func applyQuickFix(ctx context.Context, ss *ServerSet, d uriDiagnostic, menu text.Menu) error {
+	filename := text.ToPath(d.uri)
+	srv, found, err := ss.StartForFile(filename)
+	if !found {
+		return fmt.Errorf("unknown language server for %v", filename)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The server needs to know the current content of the file
+	// for the fixes to apply to it.
+	body, err := readFileBody(menu, filename)
+	if err != nil {
+		return err
+	}
+	doc := &protocol.TextDocumentIdentifier{
+		URI: d.uri,
+	}
+	if err := srv.Client.SyncDocument(ctx, &proxy.SyncDocumentParams{
+		TextDocument: *doc,
+		Content:      string(body),
+	}); err != nil {
+		return err
+	}
+
+	actions, err := srv.Client.CodeAction(ctx, &protocol.CodeActionParams{
+		TextDocument: *doc,
+		Range:        d.Range,
+		Context: protocol.CodeActionContext{
+			Diagnostics: []protocol.Diagnostic{*d.Diagnostic},
+			Only:        []protocol.CodeActionKind{protocol.QuickFix},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		return fmt.Errorf("no quick fixes available for %v", d.Message)
+	}
+	n := 0
+	if len(actions) > 1 {
+		titles := make([]string, len(actions))
+		for i := range actions {
+			titles[i] = actions[i].Title
+		}
+		n, err = choose("/LSP/QuickFixes", titles)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return nil // window deleted
 		}
 	}
-	return dw.Ctl("clean")
+	return applyCodeAction(ctx, srv.Client, doc, &actions[n], menu)
+}
+
+// setWorkspaceFolders sets the workspace folders the paths of
+// diagnostics are relative to.
+func (dw *diagWin) setWorkspaceFolders(folders []protocol.WorkspaceFolder) {
+	dirs := make([]string, len(folders))
+	for i, d := range folders {
+		dirs[i] = text.ToPath(protocol.DocumentURI(d.URI))
+	}
+	dw.mu.Lock()
+	dw.folders = dirs
+	dw.mu.Unlock()
+}
+
+func (dw *diagWin) workspaceFolders() []string {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	return dw.folders
+}
+
+// writeDiagnostics writes diags to w, sorted by file, line and severity.
+// Each diagnostic is written on a line starting with its location,
+// relative to the nearest of the workspace directories, followed by its
+// severity (E, W, I or H), source, code and message. Its related
+// locations follow on indented lines. It returns the diagnostic written
+// on each line.
+func writeDiagnostics(w io.Writer, diags map[protocol.DocumentURI][]protocol.Diagnostic, workspaces []string) []uriDiagnostic {
+	var all []uriDiagnostic
+	for uri, uriDiags := range diags {
+		for i := range uriDiags {
+			all = append(all, uriDiagnostic{uri, &uriDiags[i]})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		switch {
+		case a.uri != b.uri:
+			return a.uri < b.uri
+		case a.Range.Start.Line != b.Range.Start.Line:
+			return a.Range.Start.Line < b.Range.Start.Line
+		case diagnosticSeverity(a.Diagnostic) != diagnosticSeverity(b.Diagnostic):
+			return diagnosticSeverity(a.Diagnostic) < diagnosticSeverity(b.Diagnostic)
+		case a.Range.Start.Character != b.Range.Start.Character:
+			return a.Range.Start.Character < b.Range.Start.Character
+		}
+		return a.Message < b.Message
+	})
+
+	var lines []uriDiagnostic
+	writeLine := func(d uriDiagnostic, format string, args ...interface{}) {
+		s := fmt.Sprintf(format, args...)
+		fmt.Fprint(w, s)
+		for i := 0; i < strings.Count(s, "\n"); i++ {
+			lines = append(lines, d)
+		}
+	}
+	for _, d := range all {
+		loc := &protocol.Location{
+			URI:   d.uri,
+			Range: d.Range,
+		}
+		writeLine(d, "%v: %v %v\n", diagnosticLink(loc, workspaces), diagnosticLabel(d.Diagnostic), indentMessage(d.Message))
+		for _, ri := range d.RelatedInformation {
+			writeLine(d, "\t%v: %v\n", diagnosticLink(&ri.Location, workspaces), indentMessage(ri.Message))
+		}
+	}
+	return lines
+}
+
+// uriDiagnostic is a diagnostic for the document uri.
+type uriDiagnostic struct {
+	uri protocol.DocumentURI
+	*protocol.Diagnostic
+}
+
+// diagnosticSeverity returns the severity of diagnostic d, which is an
+// error if the server didn't set it.
+func diagnosticSeverity(d *protocol.Diagnostic) protocol.DiagnosticSeverity {
+	if d.Severity == 0 {
+		return protocol.SeverityError
+	}
+	return d.Severity
+}
+
+// diagnosticLabel returns the letter for the severity of diagnostic d,
+// followed by its source and code, as in "E compiler(UnusedVar):".
+func diagnosticLabel(d *protocol.Diagnostic) string {
+	var sev string
+	switch diagnosticSeverity(d) {
+	case protocol.SeverityWarning:
+		sev = "W"
+	case protocol.SeverityInformation:
+		sev = "I"
+	case protocol.SeverityHint:
+		sev = "H"
+	default:
+		sev = "E"
+	}
+	label := d.Source
+	if d.Code != nil && fmt.Sprint(d.Code) != "" {
+		if label != "" {
+			label += fmt.Sprintf("(%v)", d.Code)
+		} else {
+			label = fmt.Sprint(d.Code)
+		}
+	}
+	if label == "" {
+		return sev + ":"
+	}
+	return sev + " " + label + ":"
+}
+
+// indentMessage indents the lines of a multi-line message following the
+// first one, so that they're not mistaken for diagnostics.
+func indentMessage(msg string) string {
+	return strings.ReplaceAll(strings.TrimRight(msg, "\n"), "\n", "\n\t\t")
+}
+
+// diagnosticLink returns the link to location loc, relative to the
+// nearest of the workspace directories containing it.
+func diagnosticLink(loc *protocol.Location, workspaces []string) string {
+	p := text.ToPath(loc.URI)
+	basedir := ""
+	for _, dir := range workspaces {
+		if len(dir) > len(basedir) && (p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")) {
+			basedir = dir
+		}
+	}
+	return lsp.LocationLink(loc, basedir)
+}
+
+// diagnosticsFilter is the compiled form of config.DiagnosticsFilter.
+type diagnosticsFilter struct {
+	minSeverity    protocol.DiagnosticSeverity // zero if not set
+	includeSources map[string]bool
+	excludeSources map[string]bool
+	excludeMessage *regexp.Regexp
+	excludePath    *regexp.Regexp
+}
+
+// newDiagnosticsFilter compiles filter f. It returns nil if f is nil.
+func newDiagnosticsFilter(f *config.DiagnosticsFilter) (*diagnosticsFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	df := &diagnosticsFilter{}
+	if f.MinSeverity != "" {
+		sev, err := parseSeverity(f.MinSeverity)
+		if err != nil {
+			return nil, err
+		}
+		df.minSeverity = sev
+	}
+	if len(f.IncludeSources) > 0 {
+		df.includeSources = make(map[string]bool)
+		for _, src := range f.IncludeSources {
+			df.includeSources[src] = true
+		}
+	}
+	if len(f.ExcludeSources) > 0 {
+		df.excludeSources = make(map[string]bool)
+		for _, src := range f.ExcludeSources {
+			df.excludeSources[src] = true
+		}
+	}
+	var err error
+	if f.ExcludeMessage != "" {
+		if df.excludeMessage, err = regexp.Compile(f.ExcludeMessage); err != nil {
+			return nil, fmt.Errorf("compiling ExcludeMessage pattern: %v", err)
+		}
+	}
+	if f.ExcludePath != "" {
+		if df.excludePath, err = regexp.Compile(f.ExcludePath); err != nil {
+			return nil, fmt.Errorf("compiling ExcludePath pattern: %v", err)
+		}
+	}
+	return df, nil
+}
+
+// parseSeverity parses the name of a diagnostic severity.
+func parseSeverity(name string) (protocol.DiagnosticSeverity, error) {
+	switch strings.ToLower(name) {
+	case "error":
+		return protocol.SeverityError, nil
+	case "warning":
+		return protocol.SeverityWarning, nil
+	case "information", "info":
+		return protocol.SeverityInformation, nil
+	case "hint":
+		return protocol.SeverityHint, nil
+	}
+	return 0, fmt.Errorf("unknown diagnostic severity %q", name)
+}
+
+// match reports whether diagnostic d for the document uri passes filter f.
+// A nil filter matches all diagnostics.
+func (f *diagnosticsFilter) match(uri protocol.DocumentURI, d *protocol.Diagnostic) bool {
+	switch {
+	case f == nil:
+		return true
+	case f.minSeverity != 0 && diagnosticSeverity(d) > f.minSeverity:
+		return false
+	case f.includeSources != nil && !f.includeSources[d.Source]:
+		return false
+	case f.excludeSources[d.Source]:
+		return false
+	case f.excludeMessage != nil && f.excludeMessage.MatchString(d.Message):
+		return false
+	case f.excludePath != nil && f.excludePath.MatchString(text.ToPath(uri)):
+		return false
+	}
+	return true
+}
+
+// filter returns params with only the diagnostics matching f.
+func (f *diagnosticsFilter) filter(params *protocol.PublishDiagnosticsParams) *protocol.PublishDiagnosticsParams {
+	if f == nil {
+		return params
+	}
+	p := *params
+	p.Diagnostics = nil
+	for i := range params.Diagnostics {
+		if f.match(params.URI, &params.Diagnostics[i]) {
+			p.Diagnostics = append(p.Diagnostics, params.Diagnostics[i])
+		}
+	}
+	return &p
+}
+
+// filterSeverity returns the diagnostics in diags whose severity is at
+// least minSeverity, or diags if minSeverity is zero.
+func filterSeverity(diags map[protocol.DocumentURI][]protocol.Diagnostic, minSeverity protocol.DiagnosticSeverity) map[protocol.DocumentURI][]protocol.Diagnostic {
+	if minSeverity == 0 {
+		return diags
+	}
+	f := &diagnosticsFilter{minSeverity: minSeverity}
+	m := make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	for uri, uriDiags := range diags {
+		if p := f.filter(&protocol.PublishDiagnosticsParams{URI: uri, Diagnostics: uriDiags}); len(p.Diagnostics) > 0 {
+			m[uri] = p.Diagnostics
+		}
+	}
+	return m
 }
 
 func (dw *diagWin) WriteDiagnostics(params *protocol.PublishDiagnosticsParams) {
@@ -123,8 +521,11 @@ func NewDiagnosticsWriter() DiagnosticsWriter {
 			select {
 			case <-ticker.C:
 				if needsUpdate {
+					dw.updateTags(diags)
 					dw.update(diags)
 					needsUpdate = false
+				} else {
+					dw.flushTags()
 				}
 
 			case <-dw.updateChan: // user request
@@ -151,3 +552,113 @@ func restart() {
 	err = syscall.Exec(exe, os.Args, os.Environ())
 	log.Fatalf("exec: %v", err)
 }
+
+// PrintDiagnostics prints the diagnostics of all documents shown in the
+// /LSP/Diagnostics window, with paths relative to the current directory.
+func PrintDiagnostics(ctx context.Context, w io.Writer, server proxy.Server) error {
+	return printDiagnostics(ctx, w, server, "")
+}
+
+// Diagnostics prints the diagnostics of the document in the window.
+func (rc *RemoteCmd) Diagnostics(ctx context.Context) error {
+	uri, _, err := text.DocumentURI(rc.win)
+	if err != nil {
+		return err
+	}
+	return printDiagnostics(ctx, rc.Stdout, rc.server, uri)
+}
+
+// printDiagnostics prints the diagnostics of document uri, or of all
+// documents if uri is empty.
+func printDiagnostics(ctx context.Context, w io.Writer, server proxy.Server, uri protocol.DocumentURI) error {
+	result, err := server.Diagnostics(ctx, &proxy.DiagnosticsParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	diags := make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	for _, p := range result {
+		diags[p.URI] = append(diags[p.URI], p.Diagnostics...)
+	}
+	writeDiagnostics(w, diags, []string{wd})
+	return nil
+}
+
+// NextDiagnostic selects the range of the first diagnostic of the document
+// in the window that starts after the selection, wrapping around to the
+// first diagnostic. If prev is true, the last diagnostic that starts
+// before the selection is selected instead, wrapping around to the last one.
+func (rc *RemoteCmd) NextDiagnostic(ctx context.Context, prev bool) error {
+	uri, _, err := text.DocumentURI(rc.win)
+	if err != nil {
+		return err
+	}
+	result, err := rc.server.Diagnostics(ctx, &proxy.DiagnosticsParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	r, err := rc.win.Reader()
+	if err != nil {
+		return err
+	}
+	off, err := text.GetNewlineOffsets(r)
+	if err != nil {
+		return fmt.Errorf("failed to get newline offset: %v", err)
+	}
+	var addrs [][2]int
+	for _, p := range result {
+		for _, d := range p.Diagnostics {
+			addrs = append(addrs, [2]int{
+				off.LineToOffset(int(d.Range.Start.Line), int(d.Range.Start.Character)),
+				off.LineToOffset(int(d.Range.End.Line), int(d.Range.End.Character)),
+			})
+		}
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no diagnostics found")
+	}
+	q0, _, err := rc.win.CurrentAddr()
+	if err != nil {
+		return err
+	}
+	a := nextAddr(addrs, q0, prev)
+	return rc.win.SetCurrentAddr(a[0], a[1])
+}
+
+// nextAddr returns the first of the non-empty list of addresses addrs
+// that starts after q0, or the first address if there is none. If prev
+// is true, it returns the last address that starts before q0, or the
+// last address if there is none.
+func nextAddr(addrs [][2]int, q0 int, prev bool) [2]int {
+	sort.Slice(addrs, func(i, j int) bool {
+		if addrs[i][0] != addrs[j][0] {
+			return addrs[i][0] < addrs[j][0]
+		}
+		return addrs[i][1] < addrs[j][1]
+	})
+	if prev {
+		for i := len(addrs) - 1; i >= 0; i-- {
+			if addrs[i][0] < q0 {
+				return addrs[i]
+			}
+		}
+		return addrs[len(addrs)-1]
+	}
+	for _, a := range addrs {
+		if a[0] > q0 {
+			return a
+		}
+	}
+	return addrs[0]
+}
@@ -126,8 +126,9 @@ type ServerInfo struct {
 	Pattern *regexp.Regexp // filename regular expression
 	Ignore  *regexp.Regexp
 
-	Logger *log.Logger // Logger for config.Server.LogFile
-	srv    *Server     // running server instance
+	Logger     *log.Logger        // Logger for config.Server.LogFile
+	diagFilter *diagnosticsFilter // diagnostics shown
+	srv        *Server            // running server instance
 }
 
 func (info *ServerInfo) start(cfg *ClientConfig) (*Server, error) {
@@ -197,6 +198,15 @@ func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter) (*ServerSet,
 			}
 		}
 
+		filter := &cfg.DiagnosticsFilter
+		if cs.DiagnosticsFilter != nil {
+			filter = cs.DiagnosticsFilter
+		}
+		diagFilter, err := newDiagnosticsFilter(filter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DiagnosticsFilter for server %q: %v", h.ServerKey, err)
+		}
+
 		var logger *log.Logger
 		if cs.LogFile != "" {
 			f, err := os.Create(cs.LogFile)
@@ -211,14 +221,20 @@ func NewServerSet(cfg *config.Config, diagWriter DiagnosticsWriter) (*ServerSet,
 			Pattern:         re,
 			Ignore:          ignore,
 			Logger:          logger,
+			diagFilter:      diagFilter,
 		})
 	}
-	return &ServerSet{
+	ss := &ServerSet{
 		Data:       data,
 		diagWriter: diagWriter,
 		workspaces: workspaces,
 		cfg:        cfg,
-	}, nil
+	}
+	if dw, ok := diagWriter.(*diagWin); ok {
+		dw.setServerSet(ss)
+	}
+	ss.updateDiagnosticsWorkspaces()
+	return ss, nil
 }
 
 func (ss *ServerSet) FindServerWithCapability(match func(*protocol.InitializeResult) bool) (*Server, error) {
@@ -257,6 +273,7 @@ func (ss *ServerSet) ClientConfig(info *ServerInfo) *ClientConfig {
 		DiagWriter:      ss.diagWriter,
 		Workspaces:      ss.Workspaces(),
 		Logger:          info.Logger,
+		diagFilter:      info.diagFilter,
 	}
 }
 
@@ -304,7 +321,18 @@ func (ss *ServerSet) PrintTo(w io.Writer) {
 }
 
 func (ss *ServerSet) ForEach(f func(*Client) error) error {
+	return ss.ForEachWithKey("", f)
+}
+
+// ForEachWithKey is like ForEach but only calls f for the servers whose
+// key in the configuration is key, or for all servers if key is empty.
+func (ss *ServerSet) ForEachWithKey(key string, f func(*Client) error) error {
+	found := false
 	for _, info := range ss.Data {
+		if key != "" && info.ServerKey != key {
+			continue
+		}
+		found = true
 		srv, err := info.start(ss.ClientConfig(info))
 		if err != nil {
 			return err
@@ -314,6 +342,9 @@ func (ss *ServerSet) ForEach(f func(*Client) error) error {
 			return err
 		}
 	}
+	if key != "" && !found {
+		return fmt.Errorf("no server found for key %q", key)
+	}
 	return nil
 }
 
@@ -349,9 +380,18 @@ func (ss *ServerSet) DidChangeWorkspaceFolders(ctx context.Context, added, remov
 	for _, d := range removed {
 		delete(ss.workspaces, d.URI)
 	}
+	ss.updateDiagnosticsWorkspaces()
 	return nil
 }
 
+// updateDiagnosticsWorkspaces tells the diagnostics window about the
+// current workspace folders, which paths are shown relative to.
+func (ss *ServerSet) updateDiagnosticsWorkspaces() {
+	if dw, ok := ss.diagWriter.(*diagWin); ok {
+		dw.setWorkspaceFolders(ss.Workspaces())
+	}
+}
+
 // AbsDirs returns the absolute representation of directories dirs.
 func AbsDirs(dirs []string) ([]string, error) {
 	a := make([]string, len(dirs))
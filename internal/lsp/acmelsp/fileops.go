@@ -0,0 +1,328 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// fileNotifier is told about the files created, renamed or deleted by
+// a workspace edit.
+type fileNotifier interface {
+	DidCreateFiles(context.Context, *protocol.CreateFilesParams) error
+	DidRenameFiles(context.Context, *protocol.RenameFilesParams) error
+	DidDeleteFiles(context.Context, *protocol.DeleteFilesParams) error
+}
+
+// fileOperations records the resource operations done while applying
+// a workspace edit.
+type fileOperations struct {
+	created []protocol.FileCreate
+	renamed []protocol.FileRename
+	deleted []protocol.FileDelete
+}
+
+func (ops *fileOperations) notify(ctx context.Context, server fileNotifier) error {
+	if len(ops.created) > 0 {
+		if err := server.DidCreateFiles(ctx, &protocol.CreateFilesParams{Files: ops.created}); err != nil {
+			return err
+		}
+	}
+	if len(ops.renamed) > 0 {
+		if err := server.DidRenameFiles(ctx, &protocol.RenameFilesParams{Files: ops.renamed}); err != nil {
+			return err
+		}
+	}
+	if len(ops.deleted) > 0 {
+		if err := server.DidDeleteFiles(ctx, &protocol.DeleteFilesParams{Files: ops.deleted}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasResourceOperations reports whether we creates, renames or deletes files.
+func hasResourceOperations(we *protocol.WorkspaceEdit) bool {
+	for _, dc := range we.DocumentChanges {
+		if dc.CreateFile != nil || dc.RenameFile != nil || dc.DeleteFile != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDocumentChanges applies the text edits and resource operations
+// in changes in order. The server is notified of the resource
+// operations that were done, even if a later change failed.
+func applyDocumentChanges(ctx context.Context, changes []protocol.DocumentChange, menu text.Menu, server fileNotifier) error {
+	var (
+		ops fileOperations
+		err error
+	)
+	for i := range changes {
+		if err = applyDocumentChange(&changes[i], menu, &ops); err != nil {
+			break
+		}
+	}
+	if nerr := ops.notify(ctx, server); err == nil {
+		err = nerr
+	}
+	return err
+}
+
+func applyDocumentChange(dc *protocol.DocumentChange, menu text.Menu, ops *fileOperations) error {
+	switch {
+	case dc.TextDocumentEdit != nil:
+		tde := dc.TextDocumentEdit
+		edits, filtered := filterUnsupportedTextEdits(tde.Edits)
+		if filtered {
+			return fmt.Errorf("unsupported text edit type (e.g. snippet)")
+		}
+		return editFile(menu, text.ToPath(tde.TextDocument.TextDocumentIdentifier.URI), edits)
+
+	case dc.CreateFile != nil:
+		done, err := createFile(dc.CreateFile, menu)
+		if done {
+			ops.created = append(ops.created, protocol.FileCreate{URI: string(dc.CreateFile.URI)})
+		}
+		return err
+
+	case dc.RenameFile != nil:
+		done, err := renameFile(dc.RenameFile, menu)
+		if done {
+			ops.renamed = append(ops.renamed, protocol.FileRename{
+				OldURI: string(dc.RenameFile.OldURI),
+				NewURI: string(dc.RenameFile.NewURI),
+			})
+		}
+		return err
+
+	case dc.DeleteFile != nil:
+		done, err := deleteFile(dc.DeleteFile, menu)
+		if done {
+			ops.deleted = append(ops.deleted, protocol.FileDelete{URI: string(dc.DeleteFile.URI)})
+		}
+		return err
+	}
+	return nil
+}
+
+// createFile creates an empty file and opens it. It reports whether the
+// file was created, which is not the case if it already exists and the
+// operation says to ignore it.
+func createFile(op *protocol.CreateFile, menu text.Menu) (bool, error) {
+	fname := text.ToPath(op.URI)
+	var opts protocol.CreateFileOptions
+	if op.Options != nil {
+		opts = *op.Options
+	}
+	if _, err := os.Stat(fname); err == nil {
+		switch {
+		case opts.Overwrite:
+		case opts.IgnoreIfExists:
+			return false, nil
+		default:
+			return false, fmt.Errorf("cannot create %v: file already exists", fname)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(fname), 0777); err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(fname, nil, 0666); err != nil {
+		return false, err
+	}
+	if err := menu.Create(fname); err != nil {
+		return true, fmt.Errorf("failed to open %v: %v", fname, err)
+	}
+	return true, nil
+}
+
+// renameFile renames a file or directory and retargets the files open
+// in it. It reports whether the file was renamed, which is not the case
+// if the target already exists and the operation says to ignore it.
+func renameFile(op *protocol.RenameFile, menu text.Menu) (bool, error) {
+	oldname := text.ToPath(op.OldURI)
+	newname := text.ToPath(op.NewURI)
+	var opts protocol.RenameFileOptions
+	if op.Options != nil {
+		opts = *op.Options
+	}
+	if _, err := os.Stat(newname); err == nil {
+		switch {
+		case opts.Overwrite:
+		case opts.IgnoreIfExists:
+			return false, nil
+		default:
+			return false, fmt.Errorf("cannot rename %v to %v: file already exists", oldname, newname)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(newname), 0777); err != nil {
+		return false, err
+	}
+	if err := os.Rename(oldname, newname); err != nil {
+		return false, err
+	}
+	if err := menu.Rename(oldname, newname); err != nil {
+		return true, fmt.Errorf("failed to retarget windows of %v: %v", oldname, err)
+	}
+	return true, nil
+}
+
+// deleteFile closes the files open in a file or directory and deletes
+// it. It reports whether the file was deleted, which is not the case if
+// it doesn't exist and the operation says to ignore it, or if a window
+// of it can't be closed because it has unsaved changes.
+func deleteFile(op *protocol.DeleteFile, menu text.Menu) (bool, error) {
+	fname := text.ToPath(op.URI)
+	var opts protocol.DeleteFileOptions
+	if op.Options != nil {
+		opts = *op.Options
+	}
+	if _, err := os.Stat(fname); os.IsNotExist(err) && opts.IgnoreIfNotExists {
+		return false, nil
+	}
+	if err := menu.Close(fname); err != nil {
+		return false, fmt.Errorf("failed to close windows of %v: %v", fname, err)
+	}
+	remove := os.Remove
+	if opts.Recursive {
+		remove = os.RemoveAll
+	}
+	if err := remove(fname); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DidCreateFiles implements protocol.Server. The notification is only
+// sent about the files that pass the filters the server registered.
+func (s *Client) DidCreateFiles(ctx context.Context, params *protocol.CreateFilesParams) error {
+	create, _, _ := s.fileOperationFilters()
+	var files []protocol.FileCreate
+	for _, f := range params.Files {
+		if create.Match(f.URI, fileKind(f.URI)) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return s.Server.DidCreateFiles(ctx, &protocol.CreateFilesParams{Files: files})
+}
+
+// DidRenameFiles implements protocol.Server. The documents that were
+// renamed are closed; they are opened under their new name when they
+// are synced next. The notification is only sent about the files whose
+// old or new name passes the filters the server registered.
+func (s *Client) DidRenameFiles(ctx context.Context, params *protocol.RenameFilesParams) error {
+	for _, f := range params.Files {
+		if err := s.closeDocuments(ctx, f.OldURI); err != nil {
+			return err
+		}
+	}
+	_, rename, _ := s.fileOperationFilters()
+	var files []protocol.FileRename
+	for _, f := range params.Files {
+		kind := fileKind(f.NewURI)
+		if rename.Match(f.OldURI, kind) || rename.Match(f.NewURI, kind) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return s.Server.DidRenameFiles(ctx, &protocol.RenameFilesParams{Files: files})
+}
+
+// DidDeleteFiles implements protocol.Server. The documents that were
+// deleted are closed. The notification is only sent about the files
+// that pass the filters the server registered.
+func (s *Client) DidDeleteFiles(ctx context.Context, params *protocol.DeleteFilesParams) error {
+	for _, f := range params.Files {
+		if err := s.closeDocuments(ctx, f.URI); err != nil {
+			return err
+		}
+	}
+	_, _, del := s.fileOperationFilters()
+	var files []protocol.FileDelete
+	for _, f := range params.Files {
+		// The file is gone, so whether it was a folder is unknown.
+		if del.Match(f.URI, "") {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	return s.Server.DidDeleteFiles(ctx, &protocol.DeleteFilesParams{Files: files})
+}
+
+func (s *Client) fileOperationFilters() (create, rename, delete *lsp.FileOperationFilters) {
+	if s.initializeResult == nil {
+		return nil, nil, nil
+	}
+	return lsp.ServerFileOperationFilters(&s.initializeResult.Capabilities)
+}
+
+// fileKind returns "folder" if uri is a directory, "file" if it's
+// another kind of file, or an empty string if it doesn't exist.
+func fileKind(uri string) string {
+	fi, err := os.Stat(text.ToPath(protocol.DocumentURI(uri)))
+	switch {
+	case err != nil:
+		return ""
+	case fi.IsDir():
+		return "folder"
+	}
+	return "file"
+}
+
+// hasDocumentsWithin reports whether the document uri, or any document
+// within directory uri, is open.
+func (s *Client) hasDocumentsWithin(uri string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for u := range s.openDocs {
+		if withinURI(string(u), uri) {
+			return true
+		}
+	}
+	return false
+}
+
+// closeDocuments closes the open document uri, or the open documents
+// within directory uri.
+func (s *Client) closeDocuments(ctx context.Context, uri string) error {
+	s.mu.Lock()
+	var uris []protocol.DocumentURI
+	for u := range s.openDocs {
+		if withinURI(string(u), uri) {
+			uris = append(uris, u)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, u := range uris {
+		err := s.DidClose(ctx, &protocol.DidCloseTextDocumentParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: u,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withinURI reports whether uri is dir or within directory dir.
+func withinURI(uri, dir string) bool {
+	return uri == dir || strings.HasPrefix(uri, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -2,8 +2,11 @@ package acmelsp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strings"
 	"sync"
 
 	"9fans.net/acme-lsp/internal/acme"
@@ -30,16 +33,30 @@ type AcmeFileManager struct {
 	wins map[string]struct{} // set of open files
 	mu   sync.Mutex
 
+	tags   map[string]*diagTag // diagnostic counts shown in window tags, by file name
+	tagsMu sync.Mutex          // guards tags; not mu, which is held during LSP requests
+
 	cfg *config.Config
 }
 
+// diagTag is the diagnostic counts of a file shown in the tag of its window.
+type diagTag struct {
+	winid   int    // window of the file, or -1 if it's not open
+	marker  string // counts to show, e.g. "E:3 W:1", or empty if there are none
+	written string // counts written in the tag of the window
+}
+
 // NewFileManager creates a new file manager, initialized with files currently open in acme.
 func NewAcmeFileManager(ss *ServerSet, cfg *config.Config) (*AcmeFileManager, error) {
 	fm := &AcmeFileManager{
 		ss:   ss,
 		wins: make(map[string]struct{}),
+		tags: make(map[string]*diagTag),
 		cfg:  cfg,
 	}
+	if dw, ok := ss.diagWriter.(*diagWin); ok {
+		dw.setFileManager(fm)
+	}
 
 	wins, err := acme.Windows()
 	if err != nil {
@@ -126,6 +143,7 @@ func (fm *AcmeFileManager) didOpen(winid int, name string) error {
 			return fmt.Errorf("file already open in file manager: %v", name)
 		}
 		fm.wins[name] = struct{}{}
+		fm.openTag(winid, name)
 
 		b, err := w.ReadAll("body")
 		if err != nil {
@@ -143,6 +161,7 @@ func (fm *AcmeFileManager) didClose(name string) error {
 		return nil // Unknown language server.
 	}
 	delete(fm.wins, name)
+	fm.closeTag(name)
 
 	return fm.withClient(-1, name, func(c *Client, _ *acmeutil.Win) error {
 		return lsp.DidClose(context.Background(), c, name)
@@ -213,6 +232,264 @@ func (fm *AcmeFileManager) format(winid int, name string) error {
 		doc := &protocol.TextDocumentIdentifier{
 			URI: text.ToURI(name),
 		}
-		return CodeActionAndFormat(context.Background(), c, doc, w, &text.AcmeMenu{}, fm.cfg.CodeActionsOnPut)
+		_, err := CodeActionAndFormat(context.Background(), c, doc, w, &text.AcmeMenu{}, fm.cfg.CodeActionsOnPut)
+		return err
 	})
 }
+
+// setDiagnostics updates the diagnostic counts shown in the tags of the
+// windows of the files with diagnostics diags.
+func (fm *AcmeFileManager) setDiagnostics(diags map[protocol.DocumentURI][]protocol.Diagnostic) {
+	fm.tagsMu.Lock()
+	defer fm.tagsMu.Unlock()
+
+	for uri, d := range diags {
+		name := text.ToPath(uri)
+		marker := diagnosticMarker(d)
+		t, ok := fm.tags[name]
+		if !ok {
+			if marker == "" {
+				continue
+			}
+			t = &diagTag{winid: -1}
+			fm.tags[name] = t
+		}
+		t.marker = marker
+		fm.writeTag(name, t)
+		if t.marker == "" && t.winid < 0 {
+			delete(fm.tags, name)
+		}
+	}
+}
+
+// openTag shows the diagnostic counts of file name, if any,
+// in the tag of window winid.
+func (fm *AcmeFileManager) openTag(winid int, name string) {
+	fm.tagsMu.Lock()
+	defer fm.tagsMu.Unlock()
+
+	t, ok := fm.tags[name]
+	if !ok {
+		t = &diagTag{}
+		fm.tags[name] = t
+	}
+	t.winid = winid
+	t.written = ""
+
+	// Counts may be left over in the tag by a previous acme-lsp.
+	if w, err := acmeutil.OpenWin(winid); err == nil {
+		if b, err := w.ReadAll("tag"); err == nil {
+			if i := tagUserText(string(b)); i >= 0 {
+				t.written = findTagMarker(string(b)[i:])
+			}
+		}
+		w.CloseFiles()
+	}
+	fm.writeTag(name, t)
+}
+
+// closeTag forgets about the window of file name.
+func (fm *AcmeFileManager) closeTag(name string) {
+	fm.tagsMu.Lock()
+	defer fm.tagsMu.Unlock()
+
+	t, ok := fm.tags[name]
+	if !ok {
+		return
+	}
+	if t.marker == "" {
+		delete(fm.tags, name)
+		return
+	}
+	t.winid = -1
+	t.written = ""
+}
+
+// writeTag replaces the diagnostic counts written in the tag of the
+// window of file name, if it's open, by the current ones.
+func (fm *AcmeFileManager) writeTag(name string, t *diagTag) {
+	if t.winid < 0 || t.marker == t.written {
+		return
+	}
+	w, err := acmeutil.OpenWin(t.winid)
+	if err != nil {
+		if Verbose {
+			log.Printf("failed to open window of %v: %v", name, err)
+		}
+		return
+	}
+	defer w.CloseFiles()
+
+	if err := setTagMarker(w, t.written, t.marker); err != nil {
+		if err == errTagChanged {
+			return // retried by flushTags
+		}
+		if Verbose {
+			log.Printf("failed to write diagnostic counts in tag of %v: %v", name, err)
+		}
+		return
+	}
+	t.written = t.marker
+}
+
+// flushTags writes the diagnostic counts that couldn't be written in
+// window tags earlier because the user was editing them.
+func (fm *AcmeFileManager) flushTags() {
+	fm.tagsMu.Lock()
+	defer fm.tagsMu.Unlock()
+
+	for name, t := range fm.tags {
+		fm.writeTag(name, t)
+	}
+}
+
+// errTagChanged is returned by setTagMarker when the tag changed while
+// it was being updated.
+var errTagChanged = errors.New("tag changed")
+
+// setTagMarker replaces marker old by marker new in the part of the tag
+// of window w following the vertical bar, which belongs to the user.
+// The text around the markers is left as is. Nothing is written and
+// errTagChanged is returned if the tag is being edited.
+func setTagMarker(w *acmeutil.Win, old, new string) error {
+	b, err := w.ReadAll("tag")
+	if err != nil {
+		return err
+	}
+	tag := string(b)
+	i := tagUserText(tag)
+	if i < 0 {
+		return fmt.Errorf("no vertical bar in tag %q", tag)
+	}
+	user := replaceTagMarker(tag[i:], old, new)
+	if user == tag[i:] {
+		return nil
+	}
+	// Acme can only delete the text following the vertical bar, so
+	// it's written back. Make sure nothing was typed there in the
+	// meantime, so that it isn't lost.
+	b, err = w.ReadAll("tag")
+	if err != nil {
+		return err
+	}
+	if string(b) != tag {
+		return errTagChanged
+	}
+	if err := w.Ctl("cleartag"); err != nil {
+		return err
+	}
+	if user == "" {
+		return nil
+	}
+	_, err = w.Write("tag", []byte(user))
+	return err
+}
+
+// tagUserText returns the offset of the text following the vertical bar
+// in tag, which is found after the file name like acme does, or -1 if
+// there is no vertical bar. A file name containing blanks is quoted by
+// acme, with quotes within it doubled.
+func tagUserText(tag string) int {
+	i := 0
+	if strings.HasPrefix(tag, "'") {
+		for i = 1; i < len(tag); i++ {
+			if tag[i] == '\'' {
+				if i+1 < len(tag) && tag[i+1] == '\'' {
+					i++
+					continue
+				}
+				i++
+				break
+			}
+		}
+	} else if i = strings.IndexAny(tag, " \t"); i < 0 {
+		return -1
+	}
+	j := strings.IndexByte(tag[i:], '|')
+	if j < 0 {
+		return -1
+	}
+	return i + j + 1
+}
+
+// replaceTagMarker removes marker old from text s, along with the blank
+// separating it from the text around it, and appends marker new to it.
+// Only the last occurrence of old delimited by blanks is removed, so
+// that text merely containing it is never touched. Either marker may be
+// empty.
+func replaceTagMarker(s, old, new string) string {
+	if i := lastField(s, old); i >= 0 {
+		j := i + len(old)
+		if j < len(s) {
+			j++ // blank following the marker
+		} else if i > 0 {
+			i-- // blank preceding the marker
+		}
+		s = s[:i] + s[j:]
+	}
+	if new != "" {
+		if s != "" && isTagBlank(s[len(s)-1]) {
+			s += new + " "
+		} else {
+			s += " " + new
+		}
+	}
+	return s
+}
+
+// lastField returns the offset of the last occurrence of sub in s that
+// is delimited by blanks or the ends of s, or -1 if there is none.
+func lastField(s, sub string) int {
+	if sub == "" {
+		return -1
+	}
+	for end := len(s); ; {
+		i := strings.LastIndex(s[:end], sub)
+		if i < 0 {
+			return -1
+		}
+		j := i + len(sub)
+		if (i == 0 || isTagBlank(s[i-1])) && (j == len(s) || isTagBlank(s[j])) {
+			return i
+		}
+		end = j - 1
+	}
+}
+
+var tagMarkerRegexp = regexp.MustCompile(`(?:^|\s)(E:\d+ W:\d+|E:\d+|W:\d+)(?:\s|$)`)
+
+// findTagMarker returns the last diagnostic counts written in text s
+// by replaceTagMarker, or an empty string if there is none.
+func findTagMarker(s string) string {
+	m := tagMarkerRegexp.FindAllStringSubmatch(s, -1)
+	if len(m) == 0 {
+		return ""
+	}
+	return m[len(m)-1][1]
+}
+
+func isTagBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+// diagnosticMarker returns the number of errors and warnings among
+// diags, as in "E:3 W:1", or an empty string if there are none.
+func diagnosticMarker(diags []protocol.Diagnostic) string {
+	var nerr, nwarn int
+	for i := range diags {
+		switch diagnosticSeverity(&diags[i]) {
+		case protocol.SeverityError:
+			nerr++
+		case protocol.SeverityWarning:
+			nwarn++
+		}
+	}
+	var fields []string
+	if nerr > 0 {
+		fields = append(fields, fmt.Sprintf("E:%d", nerr))
+	}
+	if nwarn > 0 {
+		fields = append(fields, fmt.Sprintf("W:%d", nwarn))
+	}
+	return strings.Join(fields, " ")
+}
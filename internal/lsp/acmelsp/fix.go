@@ -0,0 +1,184 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// Fix runs the code actions of the given kinds (e.g. source.fixAll)
+// over the whole file in the window and then formats it, printing the
+// code actions applied. If preview is true, the changes are printed as
+// a unified diff instead of being made. Code actions that execute a
+// command or create, rename or delete files can't be previewed, so
+// they're skipped and printed as such.
+func (rc *RemoteCmd) Fix(ctx context.Context, kinds []protocol.CodeActionKind, preview bool) error {
+	uri, _, err := text.DocumentURI(rc.win)
+	if err != nil {
+		return err
+	}
+	doc := &protocol.TextDocumentIdentifier{
+		URI: uri,
+	}
+	initres, err := rc.server.InitializeResult(ctx, doc)
+	if err != nil {
+		return err
+	}
+	if len(lsp.CompatibleCodeActions(&initres.Capabilities, kinds)) == 0 {
+		return fmt.Errorf("language server does not provide code actions of kind %v", kinds)
+	}
+	if preview {
+		return rc.previewFix(ctx, doc, kinds)
+	}
+	actions, err := CodeActionAndFormat(ctx, rc.server, doc, rc.win, rc.menu, kinds)
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		fmt.Fprintf(rc.Stdout, "%v\n", codeActionTitle(&actions[i]))
+	}
+	return nil
+}
+
+// previewFix runs Fix on in-memory copies of the files and prints the
+// changes made to them.
+func (rc *RemoteCmd) previewFix(ctx context.Context, doc *protocol.TextDocumentIdentifier, kinds []protocol.CodeActionKind) error {
+	body, err := readBody(rc.win)
+	if err != nil {
+		return err
+	}
+	menu := &previewMenu{
+		menu:  rc.menu,
+		files: make(map[string]*previewFile),
+	}
+	f := menu.add(text.ToPath(doc.URI), string(body))
+	server := &previewServer{FormatServer: rc.server}
+
+	// The server is told about the changes made by the code actions
+	// so that the file can be formatted after them. Tell it about
+	// the unchanged window body once we're done.
+	defer rc.server.SyncDocument(ctx, &proxy.SyncDocumentParams{
+		TextDocument: *doc,
+		Content:      string(body),
+	})
+
+	actions, err := CodeActionAndFormat(ctx, server, doc, f, menu, kinds)
+	if err != nil {
+		return err
+	}
+	for i := range actions {
+		fmt.Fprintf(rc.Stdout, "%v\n", codeActionTitle(&actions[i]))
+	}
+	for i := range server.skipped {
+		fmt.Fprintf(rc.Stdout, "%v: skipped in preview\n", codeActionTitle(&server.skipped[i]))
+	}
+	names := make([]string, 0, len(menu.files))
+	for name := range menu.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pf := menu.files[name]
+		fmt.Fprint(rc.Stdout, text.Diff(name, pf.old, text.LineEdits(pf.old, string(pf.body))))
+	}
+	return nil
+}
+
+// previewServer is a FormatServer that drops the code actions whose
+// changes can't be previewed, because they execute a command or
+// create, rename or delete files.
+type previewServer struct {
+	FormatServer
+	skipped []protocol.CodeAction
+}
+
+func (s *previewServer) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
+	actions, err := s.FormatServer.CodeAction(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	var kept []protocol.CodeAction
+	for _, a := range actions {
+		if a.Command != nil || (a.Edit != nil && hasResourceOperations(a.Edit)) {
+			s.skipped = append(s.skipped, a)
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept, nil
+}
+
+// previewMenu is a text.Menu giving access to in-memory copies of the
+// files open in menu, so that edits can be previewed.
+type previewMenu struct {
+	menu  text.Menu
+	files map[string]*previewFile
+}
+
+func (m *previewMenu) add(filename, body string) *previewFile {
+	f := &previewFile{
+		filename: filename,
+		old:      body,
+		body:     []rune(body),
+	}
+	m.files[filename] = f
+	return f
+}
+
+func (m *previewMenu) Open(filename string) (text.AddressableFile, error) {
+	if f, ok := m.files[filename]; ok {
+		return f, nil
+	}
+	body, err := readFileBody(m.menu, filename)
+	if err != nil {
+		return nil, err
+	}
+	return m.add(filename, string(body)), nil
+}
+
+func (m *previewMenu) Create(filename string) error {
+	return fmt.Errorf("cannot create %v in preview", filename)
+}
+
+func (m *previewMenu) Rename(oldname, newname string) error {
+	return fmt.Errorf("cannot rename %v in preview", oldname)
+}
+
+func (m *previewMenu) Close(filename string) error {
+	return fmt.Errorf("cannot delete %v in preview", filename)
+}
+
+// previewFile is an in-memory copy of a file.
+type previewFile struct {
+	filename string
+	old      string // original content
+	body     []rune
+}
+
+func (f *previewFile) Reader() (io.Reader, error) {
+	return strings.NewReader(string(f.body)), nil
+}
+
+func (f *previewFile) WriteAt(q0, q1 int, b []byte) (int, error) {
+	if q0 < 0 || q1 > len(f.body) || q0 > q1 {
+		return 0, fmt.Errorf("range [%d, %d) out of bounds", q0, q1)
+	}
+	body := append([]rune{}, f.body[:q0]...)
+	body = append(body, []rune(string(b))...)
+	f.body = append(body, f.body[q1:]...)
+	return len(b), nil
+}
+
+func (f *previewFile) Mark() error                     { return nil }
+func (f *previewFile) DisableMark() error              { return nil }
+func (f *previewFile) Filename() (string, error)       { return f.filename, nil }
+func (f *previewFile) CurrentAddr() (int, int, error)  { return 0, 0, nil }
+func (f *previewFile) SetCurrentAddr(q0, q1 int) error { return nil }
+func (f *previewFile) CloseFiles()                     {}
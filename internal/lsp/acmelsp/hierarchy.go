@@ -0,0 +1,211 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// CallHierarchy prints the callers (if incoming is true) or the callees
+// of the symbol at the cursor position as an indented tree. The tree is
+// expanded up to depth levels below the symbol. Each line contains the
+// name of a function and a plumbable location: a call site for callers,
+// with one line per call site, and the definition for callees.
+func (rc *RemoteCmd) CallHierarchy(ctx context.Context, incoming bool, depth int) error {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return err
+	}
+	items, err := rc.server.PrepareCallHierarchy(ctx, &protocol.CallHierarchyPrepareParams{
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no call hierarchy found")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+	for _, item := range items {
+		loc := &protocol.Location{
+			URI:   item.URI,
+			Range: item.SelectionRange,
+		}
+		printHierarchyLine(rc.Stdout, 0, item.Name, loc, wd)
+		seen := map[string]int{callHierarchyKey(&item): 0}
+		if err := rc.walkCalls(ctx, &item, incoming, 1, depth, seen, wd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkCalls prints the callers or callees of item at the given level and
+// descends into them until maxDepth is reached. A function reached again,
+// through recursion or another path of the graph, is expanded only once:
+// seen maps the functions already expanded to the level they were
+// expanded at, and they are marked as already shown at the same or a
+// deeper level.
+func (rc *RemoteCmd) walkCalls(ctx context.Context, item *protocol.CallHierarchyItem, incoming bool, level, maxDepth int, seen map[string]int, wd string) error {
+	if level > maxDepth {
+		return nil
+	}
+	var (
+		next []protocol.CallHierarchyItem
+		locs [][]protocol.Location // printed for each item in next
+	)
+	if incoming {
+		calls, err := rc.server.IncomingCalls(ctx, &protocol.CallHierarchyIncomingCallsParams{
+			Item: *item,
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range calls {
+			var sites []protocol.Location
+			for _, rng := range c.FromRanges {
+				sites = append(sites, protocol.Location{URI: c.From.URI, Range: rng})
+			}
+			if len(sites) == 0 {
+				sites = append(sites, protocol.Location{URI: c.From.URI, Range: c.From.SelectionRange})
+			}
+			next = append(next, c.From)
+			locs = append(locs, sites)
+		}
+	} else {
+		calls, err := rc.server.OutgoingCalls(ctx, &protocol.CallHierarchyOutgoingCallsParams{
+			Item: *item,
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range calls {
+			next = append(next, c.To)
+			locs = append(locs, []protocol.Location{{URI: c.To.URI, Range: c.To.SelectionRange}})
+		}
+	}
+	for i := range next {
+		key := callHierarchyKey(&next[i])
+		name := next[i].Name
+		l, ok := seen[key]
+		if ok && l <= level {
+			name += alreadyShown
+		}
+		for j := range locs[i] {
+			printHierarchyLine(rc.Stdout, level, name, &locs[i][j], wd)
+		}
+		if ok && l <= level || level >= maxDepth {
+			continue
+		}
+		seen[key] = level
+		if err := rc.walkCalls(ctx, &next[i], incoming, level+1, maxDepth, seen, wd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// callHierarchyKey returns a string identifying the function item refers to.
+func callHierarchyKey(item *protocol.CallHierarchyItem) string {
+	return fmt.Sprintf("%v:%v:%v", item.URI, item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+}
+
+// alreadyShown follows the name of an item of a hierarchy whose subtree
+// was printed before.
+const alreadyShown = " (already shown)"
+
+// printHierarchyLine prints name and location loc indented by level tabs.
+func printHierarchyLine(w io.Writer, level int, name string, loc *protocol.Location, basedir string) {
+	fmt.Fprintf(w, "%v%v\t%v\n", strings.Repeat("\t", level), name, lsp.LocationLink(loc, basedir))
+}
+
+// TypeHierarchy prints the supertypes (if super is true) or the subtypes
+// of the type at the cursor position as an indented tree of plumbable
+// locations. The tree is expanded up to depth levels below the type.
+func (rc *RemoteCmd) TypeHierarchy(ctx context.Context, super bool, depth int) error {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return err
+	}
+	items, err := rc.server.PrepareTypeHierarchy(ctx, &protocol.TypeHierarchyPrepareParams{
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("no type hierarchy found")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+	for _, item := range items {
+		printHierarchyLine(rc.Stdout, 0, item.Name, typeHierarchyLocation(&item), wd)
+		seen := map[string]int{typeHierarchyKey(&item): 0}
+		if err := rc.walkTypes(ctx, &item, super, 1, depth, seen, wd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTypes is like walkCalls but for type hierarchies.
+func (rc *RemoteCmd) walkTypes(ctx context.Context, item *protocol.TypeHierarchyItem, super bool, level, maxDepth int, seen map[string]int, wd string) error {
+	if level > maxDepth {
+		return nil
+	}
+	var (
+		next []protocol.TypeHierarchyItem
+		err  error
+	)
+	if super {
+		next, err = rc.server.Supertypes(ctx, &protocol.TypeHierarchySupertypesParams{
+			Item: *item,
+		})
+	} else {
+		next, err = rc.server.Subtypes(ctx, &protocol.TypeHierarchySubtypesParams{
+			Item: *item,
+		})
+	}
+	if err != nil {
+		return err
+	}
+	for i := range next {
+		key := typeHierarchyKey(&next[i])
+		if l, ok := seen[key]; ok && l <= level {
+			printHierarchyLine(rc.Stdout, level, next[i].Name+alreadyShown, typeHierarchyLocation(&next[i]), wd)
+			continue
+		}
+		printHierarchyLine(rc.Stdout, level, next[i].Name, typeHierarchyLocation(&next[i]), wd)
+		if level >= maxDepth {
+			continue
+		}
+		seen[key] = level
+		if err := rc.walkTypes(ctx, &next[i], super, level+1, maxDepth, seen, wd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typeHierarchyLocation(item *protocol.TypeHierarchyItem) *protocol.Location {
+	return &protocol.Location{
+		URI:   item.URI,
+		Range: item.SelectionRange,
+	}
+}
+
+// typeHierarchyKey returns a string identifying the type item refers to.
+func typeHierarchyKey(item *protocol.TypeHierarchyItem) string {
+	return fmt.Sprintf("%v:%v:%v", item.URI, item.SelectionRange.Start.Line, item.SelectionRange.Start.Character)
+}
@@ -0,0 +1,67 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// DocumentHighlight finds the occurrences of the symbol at the cursor
+// position in the current window and selects the one following the
+// cursor, wrapping around to the first one. If print is true, the
+// addresses of all occurrences are printed instead.
+func (rc *RemoteCmd) DocumentHighlight(ctx context.Context, print bool) error {
+	pos, filename, err := text.Position(rc.win)
+	if err != nil {
+		return err
+	}
+	hl, err := rc.server.DocumentHighlight(ctx, &protocol.DocumentHighlightParams{
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return err
+	}
+	if len(hl) == 0 {
+		return fmt.Errorf("no occurrences found")
+	}
+	r, err := rc.win.Reader()
+	if err != nil {
+		return err
+	}
+	off, err := text.GetNewlineOffsets(r)
+	if err != nil {
+		return fmt.Errorf("failed to get newline offset: %v", err)
+	}
+	addrs := make([][2]int, len(hl))
+	for i, h := range hl {
+		addrs[i] = [2]int{
+			off.LineToOffset(int(h.Range.Start.Line), int(h.Range.Start.Character)),
+			off.LineToOffset(int(h.Range.End.Line), int(h.Range.End.Character)),
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i][0] < addrs[j][0]
+	})
+
+	if print {
+		for _, a := range addrs {
+			fmt.Fprintf(rc.Stdout, "%v:#%v,#%v\n", filename, a[0], a[1])
+		}
+		return nil
+	}
+	q0, _, err := rc.win.CurrentAddr()
+	if err != nil {
+		return err
+	}
+	next := addrs[0]
+	for _, a := range addrs {
+		if a[0] > q0 {
+			next = a
+			break
+		}
+	}
+	return rc.win.SetCurrentAddr(next[0], next[1])
+}
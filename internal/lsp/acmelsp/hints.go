@@ -0,0 +1,162 @@
+package acmelsp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/acme"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// hintsWinPrefix is the prefix of the names of the acme windows
+// showing a file annotated with inlay hints.
+const hintsWinPrefix = "/LSP/Hints"
+
+// InlayHints writes a copy of the current window body, with the inlay
+// hints (inferred types, parameter names, etc.) returned by the server
+// appended to their lines, to an acme window named /LSP/Hints/<file>.
+// Only the hints within the selection are shown, unless the selection
+// is empty.
+func (rc *RemoteCmd) InlayHints(ctx context.Context) error {
+	loc, filename, err := text.Selection(rc.win)
+	if err != nil {
+		return err
+	}
+	r, err := rc.win.Reader()
+	if err != nil {
+		return err
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	rng := loc.Range
+	if rng.Start == rng.End {
+		rng = wholeFileRange(string(body))
+	}
+	hints, err := rc.server.InlayHint(ctx, &protocol.InlayHintParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: loc.URI,
+		},
+		Range: rng,
+	})
+	if err != nil {
+		return err
+	}
+	return writeHintsWin(filename, annotateInlayHints(string(body), hints))
+}
+
+// refreshInlayHints recomputes the inlay hints shown in all the
+// /LSP/Hints windows for files handled by server, within the range
+// they were last requested for.
+func refreshInlayHints(ctx context.Context, server *Client) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		filename, ok := text.CutPrefix(info.Name, hintsWinPrefix)
+		if !ok || !server.isOpen(text.ToURI(filename)) {
+			continue
+		}
+		body, err := windowBody(filename)
+		if err != nil {
+			log.Printf("inlay hints refresh: %v", err)
+			continue
+		}
+		hints, err := server.Server.InlayHint(ctx, &protocol.InlayHintParams{
+			TextDocument: protocol.TextDocumentIdentifier{
+				URI: text.ToURI(filename),
+			},
+			Range: server.inlayHintRange(text.ToURI(filename), body),
+		})
+		if err != nil {
+			log.Printf("inlay hints refresh: %v", err)
+			continue
+		}
+		if err := writeHintsWin(filename, annotateInlayHints(body, hints)); err != nil {
+			log.Printf("inlay hints refresh: %v", err)
+		}
+	}
+	return nil
+}
+
+// windowBody returns the body of the acme window editing filename.
+func windowBody(filename string) (string, error) {
+	f, err := (&text.AcmeMenu{}).Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.CloseFiles()
+	r, err := f.Reader()
+	if err != nil {
+		return "", err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func writeHintsWin(filename, body string) error {
+	return writeWin(path.Join(hintsWinPrefix, filename), body)
+}
+
+// wholeFileRange returns the range covering all of body.
+func wholeFileRange(body string) protocol.Range {
+	line, col := text.GetLastPosition(body)
+	return protocol.Range{
+		End: protocol.Position{
+			Line:      uint32(line),
+			Character: uint32(col),
+		},
+	}
+}
+
+// annotateInlayHints returns body with the labels of hints appended
+// to the lines they belong to, after a tab. Each label is enclosed in
+// «» and preceded by the column of its position, counting from 1 in
+// runes. The text of each line is left as is, so that line and column
+// addresses in the result match body.
+func annotateInlayHints(body string, hints []protocol.InlayHint) string {
+	hints = append([]protocol.InlayHint(nil), hints...)
+	sort.SliceStable(hints, func(i, j int) bool {
+		a, b := hints[i].Position, hints[j].Position
+		if a.Line == b.Line {
+			return a.Character < b.Character
+		}
+		return a.Line < b.Line
+	})
+	lines := strings.SplitAfter(body, "\n")
+	var sb strings.Builder
+	h := 0
+	for i, line := range lines {
+		s := strings.TrimSuffix(line, "\n")
+		sb.WriteString(s)
+		rl := []rune(s)
+		sep := "\t"
+		for ; h < len(hints) && int(hints[h].Position.Line) == i; h++ {
+			c := text.RuneColumn(rl, int(hints[h].Position.Character))
+			fmt.Fprintf(&sb, "%v%v«%v»", sep, c+1, inlayHintLabel(&hints[h]))
+			sep = " "
+		}
+		sb.WriteString(line[len(s):])
+	}
+	return sb.String()
+}
+
+// inlayHintLabel returns the text shown for hint h.
+func inlayHintLabel(h *protocol.InlayHint) string {
+	var sb strings.Builder
+	for _, part := range h.Label {
+		sb.WriteString(strings.ReplaceAll(part.Value, "\n", " "))
+	}
+	return sb.String()
+}
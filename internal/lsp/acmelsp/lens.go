@@ -0,0 +1,158 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/acme"
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// lensWinPrefix is the prefix of the names of the acme windows
+// listing the code lenses of a file.
+const lensWinPrefix = "/LSP/Lens"
+
+// messagesWin is the name of the acme window where messages sent by
+// the servers with window/showMessage while executing a code lens
+// command are shown.
+const messagesWin = "/LSP/Messages"
+
+type codeLensServer interface {
+	CodeLens(context.Context, *protocol.CodeLensParams) ([]protocol.CodeLens, error)
+	ResolveCodeLens(context.Context, *protocol.CodeLens) (*protocol.CodeLens, error)
+}
+
+// codeLenses returns the code lenses of document uri, sorted by
+// position and with their command resolved.
+func codeLenses(ctx context.Context, server codeLensServer, uri protocol.DocumentURI) ([]protocol.CodeLens, error) {
+	lenses, err := server.CodeLens(ctx, &protocol.CodeLensParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range lenses {
+		if lenses[i].Command != nil {
+			continue
+		}
+		l, err := server.ResolveCodeLens(ctx, &lenses[i])
+		if err != nil {
+			log.Printf("failed to resolve code lens: %v", err)
+			continue
+		}
+		lenses[i] = *l
+	}
+	sort.SliceStable(lenses, func(i, j int) bool {
+		return lsp.ComparePositions(lenses[i].Range.Start, lenses[j].Range.Start) < 0
+	})
+	return lenses, nil
+}
+
+// formatCodeLenses returns the numbered list of lenses in document uri,
+// one per line, with their location and command title.
+func formatCodeLenses(uri protocol.DocumentURI, lenses []protocol.CodeLens) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+	var sb strings.Builder
+	for i, l := range lenses {
+		title := "(unresolved)"
+		if l.Command != nil {
+			title = l.Command.Title
+		}
+		loc := &protocol.Location{
+			URI:   uri,
+			Range: l.Range,
+		}
+		fmt.Fprintf(&sb, "%v\t%v\t%v\n", i+1, lsp.LocationLink(loc, wd), title)
+	}
+	return sb.String()
+}
+
+// CodeLens lists the code lenses of the current file in an acme window
+// named /LSP/Lens/<file>, or prints them if print is true. If n is not
+// zero, the command of the n-th lens is executed instead and its result,
+// if any, is printed.
+func (rc *RemoteCmd) CodeLens(ctx context.Context, print bool, n int) error {
+	uri, filename, err := text.DocumentURI(rc.win)
+	if err != nil {
+		return err
+	}
+	lenses, err := codeLenses(ctx, rc.server, uri)
+	if err != nil {
+		return err
+	}
+	if len(lenses) == 0 {
+		return fmt.Errorf("no code lenses found")
+	}
+	if n == 0 {
+		list := formatCodeLenses(uri, lenses)
+		if print {
+			fmt.Fprint(rc.Stdout, list)
+			return nil
+		}
+		return writeWin(path.Join(lensWinPrefix, filename), list)
+	}
+
+	if n < 1 || n > len(lenses) {
+		return fmt.Errorf("code lens %v out of range [1, %v]", n, len(lenses))
+	}
+	cmd := lenses[n-1].Command
+	if cmd == nil {
+		return fmt.Errorf("code lens %v has no command", n)
+	}
+	res, err := rc.server.ExecuteCommandOnDocument(ctx, &proxy.ExecuteCommandOnDocumentParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		ExecuteCommandParams: protocol.ExecuteCommandParams{
+			Command:   cmd.Command,
+			Arguments: cmd.Arguments,
+		},
+		ShowMessages: true,
+	})
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		return nil
+	}
+	return json.NewEncoder(rc.Stdout).Encode(res)
+}
+
+// refreshCodeLenses relists the code lenses shown in all the /LSP/Lens
+// windows for files handled by server.
+func refreshCodeLenses(ctx context.Context, server *Client) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		filename, ok := text.CutPrefix(info.Name, lensWinPrefix)
+		if !ok || !server.isOpen(text.ToURI(filename)) {
+			continue
+		}
+		uri := text.ToURI(filename)
+		lenses, err := codeLenses(ctx, server, uri)
+		if err != nil {
+			log.Printf("code lens refresh: %v", err)
+			continue
+		}
+		if err := writeWin(info.Name, formatCodeLenses(uri, lenses)); err != nil {
+			log.Printf("code lens refresh: %v", err)
+		}
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"sync"
 
 	"9fans.net/acme-lsp/internal/lsp"
 	"9fans.net/acme-lsp/internal/lsp/acmelsp/config"
@@ -18,6 +20,13 @@ type proxyServer struct {
 	ss *ServerSet // client connections to upstream LSP server (e.g. gopls)
 	fm FileManager
 	proxy.NotImplementedServer
+
+	// Servers that returned call or type hierarchy items, by the URI
+	// of the items. The items may be in files no server handles, such
+	// as the jdt:// URIs of the Java standard library, so the requests
+	// about them go to the server they came from.
+	hierarchyServers map[protocol.DocumentURI]*Server
+	mu               sync.Mutex
 }
 
 func (s *proxyServer) Version(ctx context.Context) (int, error) {
@@ -48,6 +57,22 @@ func (s *proxyServer) Completion(ctx context.Context, params *protocol.Completio
 	return srv.Client.Completion(ctx, params)
 }
 
+func (s *proxyServer) ResolveCompletionItemOnDocument(ctx context.Context, params *proxy.ResolveCompletionItemOnDocumentParams) (*protocol.CompletionItem, error) {
+	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("ResolveCompletionItemOnDocument: %v", err)
+	}
+	return srv.Client.ResolveCompletionItemOnDocument(ctx, params)
+}
+
+func (s *proxyServer) Declaration(ctx context.Context, params *protocol.DeclarationParams) (*protocol.Or_textDocument_declaration, error) {
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Declaration: %v", err)
+	}
+	return srv.Client.Declaration(ctx, params)
+}
+
 func (s *proxyServer) Definition(ctx context.Context, params *protocol.DefinitionParams) (*protocol.Or_Result_textDocument_definition, error) {
 	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
 	if err != nil {
@@ -67,6 +92,38 @@ func (s *proxyServer) Formatting(ctx context.Context, params *protocol.DocumentF
 	return srv.Client.Formatting(ctx, params)
 }
 
+func (s *proxyServer) RangeFormatting(ctx context.Context, params *protocol.DocumentRangeFormattingParams) ([]protocol.TextEdit, error) {
+	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("RangeFormatting: %v", err)
+	}
+	cfg := s.ss.ServerConfigForFile(text.ToPath(params.TextDocument.URI))
+	// override formatting options with user config
+	params.Options = cfg.FormattingOptions
+	return srv.Client.RangeFormatting(ctx, params)
+}
+
+func (s *proxyServer) RangesFormatting(ctx context.Context, params *protocol.DocumentRangesFormattingParams) ([]protocol.TextEdit, error) {
+	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("RangesFormatting: %v", err)
+	}
+	cfg := s.ss.ServerConfigForFile(text.ToPath(params.TextDocument.URI))
+	// override formatting options with user config
+	params.Options = cfg.FormattingOptions
+	return srv.Client.RangesFormatting(ctx, params)
+}
+
+func (s *proxyServer) CodeLens(ctx context.Context, params *protocol.CodeLensParams) ([]protocol.CodeLens, error) {
+	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("CodeLens: %v", err)
+	}
+	// Resolve the lenses here because the resolve request
+	// doesn't contain the URI needed to find the server.
+	return codeLenses(ctx, srv.Client, params.TextDocument.URI)
+}
+
 func (s *proxyServer) CodeAction(ctx context.Context, params *protocol.CodeActionParams) ([]protocol.CodeAction, error) {
 	srv, err := serverForURI(s.ss, params.TextDocument.URI)
 	if err != nil {
@@ -80,7 +137,7 @@ func (s *proxyServer) ExecuteCommandOnDocument(ctx context.Context, params *prox
 	if err != nil {
 		return nil, fmt.Errorf("ExecuteCommandOnDocument: %v", err)
 	}
-	return srv.Client.ExecuteCommand(ctx, &params.ExecuteCommandParams)
+	return srv.Client.ExecuteCommandOnDocument(ctx, params)
 }
 
 func (s *proxyServer) SyncDocument(ctx context.Context, params *proxy.SyncDocumentParams) error {
@@ -93,12 +150,7 @@ func (s *proxyServer) SyncDocument(ctx context.Context, params *proxy.SyncDocume
 
 func (s *proxyServer) ExecuteCommand(ctx context.Context, params *protocol.ExecuteCommandParams) (interface{}, error) {
 	srv, err := s.ss.FindServerWithCapability(func(initResult *protocol.InitializeResult) bool {
-		for _, name := range initResult.Capabilities.ExecuteCommandProvider.Commands {
-			if name == params.Command {
-				return true
-			}
-		}
-		return false
+		return containsString(lsp.ServerCommands(&initResult.Capabilities), params.Command)
 	})
 	if err != nil {
 		return nil, fmt.Errorf("ExecuteCommand: server with command %v not found: %v", params.Command, err)
@@ -106,6 +158,87 @@ func (s *proxyServer) ExecuteCommand(ctx context.Context, params *protocol.Execu
 	return srv.Client.ExecuteCommand(ctx, params)
 }
 
+func (s *proxyServer) ExecuteCommandOnServer(ctx context.Context, params *proxy.ExecuteCommandOnServerParams) (interface{}, error) {
+	var (
+		result interface{}
+		done   bool
+	)
+	err := s.ss.ForEachWithKey(params.ServerKey, func(c *Client) error {
+		if done {
+			return nil
+		}
+		done = true
+		var err error
+		result, err = c.ExecuteCommand(ctx, &params.ExecuteCommandParams)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ExecuteCommandOnServer: %v", err)
+	}
+	return result, nil
+}
+
+func (s *proxyServer) Diagnostics(ctx context.Context, params *proxy.DiagnosticsParams) ([]protocol.PublishDiagnosticsParams, error) {
+	diags := make(map[protocol.DocumentURI][]protocol.Diagnostic)
+	for _, info := range s.ss.Data {
+		if info.srv == nil {
+			continue // not started, so there are no diagnostics
+		}
+		result, err := info.srv.Client.Diagnostics(ctx, params)
+		if err != nil {
+			return nil, fmt.Errorf("Diagnostics: %v", err)
+		}
+		for _, p := range result {
+			diags[p.URI] = append(diags[p.URI], p.Diagnostics...)
+		}
+	}
+	result := make([]protocol.PublishDiagnosticsParams, 0, len(diags))
+	for uri, d := range diags {
+		result = append(result, protocol.PublishDiagnosticsParams{
+			URI:         uri,
+			Diagnostics: d,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URI < result[j].URI
+	})
+	return result, nil
+}
+
+func (s *proxyServer) Commands(ctx context.Context) ([]proxy.ServerCommands, error) {
+	var result []proxy.ServerCommands
+	seen := make(map[string]bool)
+	for _, info := range s.ss.Data {
+		// Only list the commands of running servers, instead of
+		// starting all of them.
+		if info.srv == nil {
+			continue
+		}
+		cmds, err := info.srv.Client.Commands(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Commands: %v", err)
+		}
+		for _, sc := range cmds {
+			if !seen[sc.ServerKey] {
+				seen[sc.ServerKey] = true
+				result = append(result, sc)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ServerKey < result[j].ServerKey
+	})
+	return result, nil
+}
+
+func (s *proxyServer) DocumentHighlight(ctx context.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("DocumentHighlight: %v", err)
+	}
+	return srv.Client.DocumentHighlight(ctx, params)
+}
+
 func (s *proxyServer) Hover(ctx context.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
 	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
 	if err != nil {
@@ -122,6 +255,86 @@ func (s *proxyServer) Implementation(ctx context.Context, params *protocol.Imple
 	return srv.Client.Implementation(ctx, params)
 }
 
+func (s *proxyServer) PrepareCallHierarchy(ctx context.Context, params *protocol.CallHierarchyPrepareParams) ([]protocol.CallHierarchyItem, error) {
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("PrepareCallHierarchy: %v", err)
+	}
+	items, err := srv.Client.PrepareCallHierarchy(ctx, params)
+	for _, item := range items {
+		s.setHierarchyServer(item.URI, srv)
+	}
+	return items, err
+}
+
+func (s *proxyServer) IncomingCalls(ctx context.Context, params *protocol.CallHierarchyIncomingCallsParams) ([]protocol.CallHierarchyIncomingCall, error) {
+	srv, err := s.hierarchyServer(params.Item.URI)
+	if err != nil {
+		return nil, fmt.Errorf("IncomingCalls: %v", err)
+	}
+	calls, err := srv.Client.IncomingCalls(ctx, params)
+	for _, c := range calls {
+		s.setHierarchyServer(c.From.URI, srv)
+	}
+	return calls, err
+}
+
+func (s *proxyServer) OutgoingCalls(ctx context.Context, params *protocol.CallHierarchyOutgoingCallsParams) ([]protocol.CallHierarchyOutgoingCall, error) {
+	srv, err := s.hierarchyServer(params.Item.URI)
+	if err != nil {
+		return nil, fmt.Errorf("OutgoingCalls: %v", err)
+	}
+	calls, err := srv.Client.OutgoingCalls(ctx, params)
+	for _, c := range calls {
+		s.setHierarchyServer(c.To.URI, srv)
+	}
+	return calls, err
+}
+
+func (s *proxyServer) PrepareTypeHierarchy(ctx context.Context, params *protocol.TypeHierarchyPrepareParams) ([]protocol.TypeHierarchyItem, error) {
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("PrepareTypeHierarchy: %v", err)
+	}
+	items, err := srv.Client.PrepareTypeHierarchy(ctx, params)
+	for _, item := range items {
+		s.setHierarchyServer(item.URI, srv)
+	}
+	return items, err
+}
+
+func (s *proxyServer) Supertypes(ctx context.Context, params *protocol.TypeHierarchySupertypesParams) ([]protocol.TypeHierarchyItem, error) {
+	srv, err := s.hierarchyServer(params.Item.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Supertypes: %v", err)
+	}
+	items, err := srv.Client.Supertypes(ctx, params)
+	for _, item := range items {
+		s.setHierarchyServer(item.URI, srv)
+	}
+	return items, err
+}
+
+func (s *proxyServer) Subtypes(ctx context.Context, params *protocol.TypeHierarchySubtypesParams) ([]protocol.TypeHierarchyItem, error) {
+	srv, err := s.hierarchyServer(params.Item.URI)
+	if err != nil {
+		return nil, fmt.Errorf("Subtypes: %v", err)
+	}
+	items, err := srv.Client.Subtypes(ctx, params)
+	for _, item := range items {
+		s.setHierarchyServer(item.URI, srv)
+	}
+	return items, err
+}
+
+func (s *proxyServer) InlayHint(ctx context.Context, params *protocol.InlayHintParams) ([]protocol.InlayHint, error) {
+	srv, err := serverForURI(s.ss, params.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("InlayHint: %v", err)
+	}
+	return srv.Client.InlayHint(ctx, params)
+}
+
 func (s *proxyServer) References(ctx context.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
 	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
 	if err != nil {
@@ -130,6 +343,14 @@ func (s *proxyServer) References(ctx context.Context, params *protocol.Reference
 	return srv.Client.References(ctx, params)
 }
 
+func (s *proxyServer) PrepareRename(ctx context.Context, params *protocol.PrepareRenameParams) (*protocol.PrepareRenameResult, error) {
+	srv, err := serverForURI(s.ss, params.TextDocumentPositionParams.TextDocument.URI)
+	if err != nil {
+		return nil, fmt.Errorf("PrepareRename: %v", err)
+	}
+	return srv.Client.PrepareRename(ctx, params)
+}
+
 func (s *proxyServer) Rename(ctx context.Context, params *protocol.RenameParams) (*protocol.WorkspaceEdit, error) {
 	srv, err := serverForURI(s.ss, params.TextDocument.URI)
 	if err != nil {
@@ -155,16 +376,25 @@ func (s *proxyServer) DocumentSymbol(ctx context.Context, params *protocol.Docum
 }
 
 func (s *proxyServer) Symbol(ctx context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
-	var symbols []protocol.SymbolInformation
-	err := s.ss.ForEach(func(c *Client) error {
-		resp, err := c.Symbol(ctx, params)
+	return s.WorkspaceSymbols(ctx, &proxy.WorkspaceSymbolsParams{
+		WorkspaceSymbolParams: *params,
+	})
+}
+
+func (s *proxyServer) WorkspaceSymbols(ctx context.Context, params *proxy.WorkspaceSymbolsParams) ([]protocol.SymbolInformation, error) {
+	var symbols []workspaceSymbol
+	err := s.ss.ForEachWithKey(params.ServerKey, func(c *Client) error {
+		resp, err := c.workspaceSymbols(ctx, &params.WorkspaceSymbolParams)
 		if err != nil {
 			return err
 		}
 		symbols = append(symbols, resp...)
 		return nil
 	})
-	return symbols, err
+	if err != nil {
+		return nil, err
+	}
+	return resolveWorkspaceSymbols(ctx, selectWorkspaceSymbols(params, symbols))
 }
 
 func (s *proxyServer) TypeDefinition(ctx context.Context, params *protocol.TypeDefinitionParams) (*protocol.Or_Result_textDocument_typeDefinition, error) {
@@ -175,6 +405,116 @@ func (s *proxyServer) TypeDefinition(ctx context.Context, params *protocol.TypeD
 	return srv.Client.TypeDefinition(ctx, params)
 }
 
+func (s *proxyServer) DidCreateFiles(ctx context.Context, params *protocol.CreateFilesParams) error {
+	files := make(map[*Server][]protocol.FileCreate)
+	for _, f := range params.Files {
+		for _, srv := range s.fileOperationServers(f.URI) {
+			files[srv] = append(files[srv], f)
+		}
+	}
+	for srv, f := range files {
+		if err := srv.Client.DidCreateFiles(ctx, &protocol.CreateFilesParams{Files: f}); err != nil {
+			return fmt.Errorf("DidCreateFiles: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *proxyServer) DidRenameFiles(ctx context.Context, params *protocol.RenameFilesParams) error {
+	files := make(map[*Server][]protocol.FileRename)
+	for _, f := range params.Files {
+		for _, srv := range s.fileOperationServers(f.NewURI, f.OldURI) {
+			files[srv] = append(files[srv], f)
+		}
+	}
+	for srv, f := range files {
+		if err := srv.Client.DidRenameFiles(ctx, &protocol.RenameFilesParams{Files: f}); err != nil {
+			return fmt.Errorf("DidRenameFiles: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *proxyServer) DidDeleteFiles(ctx context.Context, params *protocol.DeleteFilesParams) error {
+	files := make(map[*Server][]protocol.FileDelete)
+	for _, f := range params.Files {
+		for _, srv := range s.fileOperationServers(f.URI) {
+			files[srv] = append(files[srv], f)
+		}
+	}
+	for srv, f := range files {
+		if err := srv.Client.DidDeleteFiles(ctx, &protocol.DeleteFilesParams{Files: f}); err != nil {
+			return fmt.Errorf("DidDeleteFiles: %v", err)
+		}
+	}
+	return nil
+}
+
+// fileOperationServers returns the servers to notify of an operation on
+// the file or directory uris (its new and old names for a rename). That's
+// the server handling the file if there is one. Otherwise, for example
+// for a directory, it's the running servers with any of uris within a
+// workspace folder or with open documents within any of uris.
+func (s *proxyServer) fileOperationServers(uris ...string) []*Server {
+	for _, uri := range uris {
+		if srv, err := serverForURI(s.ss, protocol.DocumentURI(uri)); err == nil {
+			return []*Server{srv}
+		}
+	}
+	inWorkspace := false
+	for _, d := range s.ss.Workspaces() {
+		for _, uri := range uris {
+			if withinURI(uri, string(d.URI)) {
+				inWorkspace = true
+			}
+		}
+	}
+	var servers []*Server
+	seen := make(map[*Server]bool)
+	for _, info := range s.ss.Data {
+		srv := info.srv
+		if srv == nil || seen[srv] {
+			continue // not started, so it doesn't know about the files
+		}
+		seen[srv] = true
+		open := false
+		for _, uri := range uris {
+			if srv.Client.hasDocumentsWithin(uri) {
+				open = true
+			}
+		}
+		if inWorkspace || open {
+			servers = append(servers, srv)
+		}
+	}
+	return servers
+}
+
+// hierarchyServer returns the server that returned the call or type
+// hierarchy items in document uri, or the server handling uri if there
+// is none.
+func (s *proxyServer) hierarchyServer(uri protocol.DocumentURI) (*Server, error) {
+	s.mu.Lock()
+	srv := s.hierarchyServers[uri]
+	s.mu.Unlock()
+	if srv != nil {
+		return srv, nil
+	}
+	return serverForURI(s.ss, uri)
+}
+
+// setHierarchyServer records that srv returned call or type hierarchy
+// items in document uri.
+func (s *proxyServer) setHierarchyServer(uri protocol.DocumentURI, srv *Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hierarchyServers == nil {
+		s.hierarchyServers = make(map[protocol.DocumentURI]*Server)
+	}
+	s.hierarchyServers[uri] = srv
+}
+
 func serverForURI(ss *ServerSet, uri protocol.DocumentURI) (*Server, error) {
 	filename := text.ToPath(uri)
 	srv, found, err := ss.StartForFile(filename)
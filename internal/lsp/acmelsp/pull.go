@@ -0,0 +1,226 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// Some servers never publish diagnostics and only send them in reply
+// to textDocument/diagnostic and workspace/diagnostic requests. The
+// diagnostics pulled from them go through PublishDiagnostics, the same
+// way as pushed ones.
+
+// pullDelay is how long to wait for more changes to a document before
+// pulling its diagnostics.
+var pullDelay = 100 * time.Millisecond
+
+// pendingPull is a scheduled or in-flight textDocument/diagnostic request.
+type pendingPull struct {
+	timer  *time.Timer
+	cancel context.CancelFunc
+}
+
+// schedulePull arranges for the diagnostics of the open document uri
+// to be pulled after pullDelay, replacing any pending pull for it, so
+// that a burst of changes only results in one request. A replaced
+// request in flight is canceled since its result is out of date. It
+// must be called with s.mu held.
+func (s *Client) schedulePull(uri protocol.DocumentURI) {
+	s.cancelPull(uri)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &pendingPull{cancel: cancel}
+	p.timer = time.AfterFunc(pullDelay, func() {
+		defer func() {
+			s.mu.Lock()
+			if s.pulls[uri] == p {
+				delete(s.pulls, uri)
+			}
+			s.mu.Unlock()
+			cancel()
+		}()
+		s.pullDiagnostics(ctx, uri)
+	})
+	s.pulls[uri] = p
+}
+
+// cancelPull cancels the pending pull for uri, if any. It must be
+// called with s.mu held.
+func (s *Client) cancelPull(uri protocol.DocumentURI) {
+	if p, ok := s.pulls[uri]; ok {
+		p.timer.Stop()
+		p.cancel()
+		delete(s.pulls, uri)
+	}
+}
+
+// pullDiagnostics requests the diagnostics of the open document uri,
+// if the server supports it. Errors are logged because this is always
+// done in the background.
+func (s *Client) pullDiagnostics(ctx context.Context, uri protocol.DocumentURI) {
+	if s.initializeResult == nil || s.handler == nil {
+		return
+	}
+	if ok, _ := lsp.ServerProvidesPullDiagnostics(&s.initializeResult.Capabilities); !ok {
+		return
+	}
+	version, ok := s.docVersion(uri)
+	if !ok {
+		return
+	}
+	h := s.handler
+	report, err := s.Server.Diagnostic(ctx, &protocol.DocumentDiagnosticParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+		PreviousResultID: h.resultID(uri),
+	})
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("pull diagnostics for %v: %v", uri, err)
+		}
+		return
+	}
+	if report == nil {
+		return
+	}
+	if v, ok := s.docVersion(uri); !ok || v != version || ctx.Err() != nil {
+		return // document changed; a newer pull will follow
+	}
+	switch v := report.Value.(type) {
+	case protocol.RelatedFullDocumentDiagnosticReport:
+		h.publishReport(ctx, uri, &v.FullDocumentDiagnosticReport)
+		h.publishRelatedReports(ctx, v.RelatedDocuments)
+	case protocol.RelatedUnchangedDocumentDiagnosticReport:
+		h.publishRelatedReports(ctx, v.RelatedDocuments)
+	}
+}
+
+// pullWorkspaceDiagnostics requests the diagnostics of all the files
+// in the workspace, if the server supports it. Open documents are
+// skipped because pullDiagnostics keeps them up to date.
+func (s *Client) pullWorkspaceDiagnostics(ctx context.Context) {
+	if s.initializeResult == nil || s.handler == nil {
+		return
+	}
+	if _, ok := lsp.ServerProvidesPullDiagnostics(&s.initializeResult.Capabilities); !ok {
+		return
+	}
+	// Servers may hold the request until something changes,
+	// so don't pile up requests.
+	s.mu.Lock()
+	if s.pullingWorkspace {
+		s.mu.Unlock()
+		return
+	}
+	s.pullingWorkspace = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.pullingWorkspace = false
+		s.mu.Unlock()
+	}()
+
+	h := s.handler
+	report, err := s.Server.DiagnosticWorkspace(ctx, &protocol.WorkspaceDiagnosticParams{
+		PreviousResultIds: h.previousResultIDs(),
+	})
+	if err != nil {
+		log.Printf("pull workspace diagnostics: %v", err)
+		return
+	}
+	if report == nil {
+		return
+	}
+	for _, item := range report.Items {
+		switch v := item.Value.(type) {
+		case protocol.WorkspaceFullDocumentDiagnosticReport:
+			if !s.isOpen(v.URI) {
+				h.publishReport(ctx, v.URI, &v.FullDocumentDiagnosticReport)
+			}
+		case protocol.WorkspaceUnchangedDocumentDiagnosticReport:
+			h.setResultID(v.URI, v.ResultID)
+		}
+	}
+}
+
+// refreshDiagnostics pulls again the diagnostics of all the open
+// documents and of the workspace.
+func (s *Client) refreshDiagnostics(ctx context.Context) {
+	s.mu.Lock()
+	for uri := range s.openDocs {
+		s.schedulePull(uri)
+	}
+	s.mu.Unlock()
+
+	s.pullWorkspaceDiagnostics(ctx)
+}
+
+// docVersion returns the version of the open document uri.
+func (s *Client) docVersion(uri protocol.DocumentURI) (int32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state, ok := s.openDocs[uri]
+	return state.version, ok
+}
+
+// publishReport publishes the diagnostics in report r for uri, unless
+// they are unchanged since the last report.
+func (h *clientHandler) publishReport(ctx context.Context, uri protocol.DocumentURI, r *protocol.FullDocumentDiagnosticReport) {
+	h.setResultID(uri, r.ResultID)
+	if r.Kind == "unchanged" {
+		return
+	}
+	h.PublishDiagnostics(ctx, &protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: r.Items,
+	})
+}
+
+// publishRelatedReports publishes the reports of the documents related
+// to a pulled document. They are either full or unchanged reports.
+func (h *clientHandler) publishRelatedReports(ctx context.Context, related map[protocol.DocumentURI]interface{}) {
+	for uri, v := range related {
+		b, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		var r protocol.FullDocumentDiagnosticReport
+		if err := json.Unmarshal(b, &r); err != nil {
+			log.Printf("bad diagnostic report for %v: %v", uri, err)
+			continue
+		}
+		h.publishReport(ctx, uri, &r)
+	}
+}
+
+func (h *clientHandler) resultID(uri protocol.DocumentURI) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.resultIDs[uri]
+}
+
+func (h *clientHandler) setResultID(uri protocol.DocumentURI, id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id == "" {
+		delete(h.resultIDs, uri)
+	} else {
+		h.resultIDs[uri] = id
+	}
+}
+
+// previousResultIDs returns the result ids of the last pulled reports.
+func (h *clientHandler) previousResultIDs() []protocol.PreviousResultID {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := []protocol.PreviousResultID{}
+	for uri, id := range h.resultIDs {
+		ids = append(ids, protocol.PreviousResultID{URI: uri, Value: id})
+	}
+	return ids
+}
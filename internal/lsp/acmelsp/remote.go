@@ -3,13 +3,12 @@ package acmelsp
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -90,29 +89,25 @@ func (rc *RemoteCmd) Completion(ctx context.Context, kind CompletionKind) error
 		return fmt.Errorf("no completion")
 	}
 
-	if (kind == CompleteInsertFirstMatch && len(result.Items) >= 1) || (kind == CompleteInsertOnlyMatch && len(result.Items) == 1) {
-		textEdit := result.Items[0].TextEdit
-		switch edit := textEdit.Value.(type) {
-		default:
-			return fmt.Errorf("unsupported completion text edit %T", edit)
-		case nil:
-			// TODO(fhs): Use insertText or label instead.
-			return fmt.Errorf("nil TextEdit in completion item")
-		case protocol.TextEdit:
-			if err := text.Edit(rc.win, []protocol.TextEdit{edit}); err != nil {
-				return fmt.Errorf("failed to apply completion edit: %v", err)
-			}
-			if len(result.Items) == 1 {
-				return nil
-			}
+	if kind == CompleteNoEdit || (kind == CompleteInsertOnlyMatch && len(result.Items) > 1) {
+		for _, item := range result.Items {
+			fmt.Fprintf(rc.Stdout, "%v\t%v\n", item.Label, item.Detail)
 		}
+		return nil
 	}
 
-	var sb strings.Builder
-	for _, item := range result.Items {
-		fmt.Fprintf(&sb, "%v\t%v\n", item.Label, item.Detail)
+	ins, err := newCompletionInserter(rc.server, rc.win, &pos.TextDocument)
+	if err != nil {
+		return err
+	}
+	doc, err := ins.insert(ctx, &result.Items[0])
+	if err != nil {
+		return err
+	}
+	if len(result.Items) == 1 {
+		return nil
 	}
-	return rc.showCompletion(sb.String(), kind)
+	return runCompletionWin(ctx, ins, result.Items, doc)
 }
 
 // completionListFromResult converts an Or_Result_textDocument_completion response
@@ -131,26 +126,6 @@ func completionListFromResult(result *protocol.Or_Result_textDocument_completion
 	return nil
 }
 
-func (rc *RemoteCmd) showCompletion(body string, kind CompletionKind) error {
-	if kind == CompleteInsertFirstMatch {
-		cw, err := acmeutil.Hijack("/LSP/Completions")
-		if err != nil {
-			cw, err = acmeutil.NewWin()
-			if err != nil {
-				return err
-			}
-			cw.Name("/LSP/Completions")
-		}
-		defer cw.Win.Ctl("clean")
-
-		cw.Clear()
-		cw.PrintTabbed(body)
-		return nil
-	}
-	fmt.Fprint(rc.Stdout, body)
-	return nil
-}
-
 func (rc *RemoteCmd) Definition(ctx context.Context, print bool) error {
 	pos, _, err := text.Position(rc.win)
 	if err != nil {
@@ -172,6 +147,27 @@ func (rc *RemoteCmd) Definition(ctx context.Context, print bool) error {
 	return PlumbLocations(locations)
 }
 
+func (rc *RemoteCmd) Declaration(ctx context.Context, print bool) error {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return fmt.Errorf("failed to get position: %v", err)
+	}
+	result, err := rc.server.Declaration(ctx, &protocol.DeclarationParams{
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return fmt.Errorf("bad server response: %v", err)
+	}
+	locations := locationsFromDeclaration(result)
+	if len(locations) == 0 {
+		return fmt.Errorf("no declaration found")
+	}
+	if print {
+		return PrintLocations(rc.Stdout, locations)
+	}
+	return PlumbLocations(locations)
+}
+
 func (rc *RemoteCmd) OrganizeImportsAndFormat(ctx context.Context) error {
 	uri, _, err := text.DocumentURI(rc.win)
 	if err != nil {
@@ -181,11 +177,145 @@ func (rc *RemoteCmd) OrganizeImportsAndFormat(ctx context.Context) error {
 	doc := &protocol.TextDocumentIdentifier{
 		URI: uri,
 	}
-	return CodeActionAndFormat(ctx, rc.server, doc, rc.win, rc.menu, []protocol.CodeActionKind{
+	_, err = CodeActionAndFormat(ctx, rc.server, doc, rc.win, rc.menu, []protocol.CodeActionKind{
 		protocol.SourceOrganizeImports,
 	})
+	return err
+}
+
+// Format formats the given ranges of the current window buffer, or the
+// selection if no range is given and the selection is not empty. If
+// there is no range to format, or the selection can't be formatted
+// because the server doesn't support range formatting, imports are
+// organized and the whole buffer is formatted instead.
+func (rc *RemoteCmd) Format(ctx context.Context, ranges []protocol.Range) error {
+	loc, _, err := text.Selection(rc.win)
+	if err != nil {
+		return err
+	}
+	explicit := len(ranges) > 0
+	if !explicit && loc.Range.Start != loc.Range.End {
+		ranges = []protocol.Range{loc.Range}
+	}
+	if len(ranges) == 0 {
+		return rc.OrganizeImportsAndFormat(ctx)
+	}
+
+	doc := protocol.TextDocumentIdentifier{
+		URI: loc.URI,
+	}
+	initres, err := rc.server.InitializeResult(ctx, &doc)
+	if err != nil {
+		return err
+	}
+	ok, multi := lsp.ServerProvidesRangeFormatting(&initres.Capabilities)
+	switch {
+	case !ok && explicit:
+		return fmt.Errorf("language server does not support range formatting")
+	case !ok:
+		return rc.OrganizeImportsAndFormat(ctx)
+	case multi && len(ranges) > 1:
+		edits, err := rc.server.RangesFormatting(ctx, &protocol.DocumentRangesFormattingParams{
+			TextDocument: doc,
+			Ranges:       ranges,
+		})
+		if err != nil {
+			return err
+		}
+		if err := text.Edit(rc.win, edits); err != nil {
+			return fmt.Errorf("failed to apply edits: %v", err)
+		}
+		return nil
+	}
+
+	// Format the ranges one at a time, starting from the end of the
+	// file so that edits don't move the ranges that are not formatted yet.
+	sort.Slice(ranges, func(i, j int) bool {
+		return lsp.ComparePositions(ranges[i].Start, ranges[j].Start) > 0
+	})
+	for i, rng := range ranges {
+		if i > 0 {
+			if err := rc.SyncDocument(ctx); err != nil {
+				return err
+			}
+		}
+		edits, err := rc.server.RangeFormatting(ctx, &protocol.DocumentRangeFormattingParams{
+			TextDocument: doc,
+			Range:        rng,
+		})
+		if err != nil {
+			return err
+		}
+		if err := text.Edit(rc.win, edits); err != nil {
+			return fmt.Errorf("failed to apply edits: %v", err)
+		}
+	}
+	return nil
+}
+
+// CodeAction lists the code actions available for the current selection
+// and the diagnostics overlapping it. If print is true, the numbered list
+// of actions is printed. Otherwise, the n-th action is applied, or the
+// user chooses the action in an acme window named /LSP/CodeActions if
+// n is zero.
+func (rc *RemoteCmd) CodeAction(ctx context.Context, print bool, n int) error {
+	loc, _, err := text.Selection(rc.win)
+	if err != nil {
+		return err
+	}
+	doc := &protocol.TextDocumentIdentifier{
+		URI: loc.URI,
+	}
+	actions, err := rc.server.CodeAction(ctx, &protocol.CodeActionParams{
+		TextDocument: *doc,
+		Range:        loc.Range,
+		Context: protocol.CodeActionContext{
+			Diagnostics: []protocol.Diagnostic{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		return fmt.Errorf("no code actions available")
+	}
+
+	titles := make([]string, len(actions))
+	for i := range actions {
+		titles[i] = codeActionTitle(&actions[i])
+	}
+	if print {
+		for i, t := range titles {
+			fmt.Fprintf(rc.Stdout, "%v\t%v\n", i+1, t)
+		}
+		return nil
+	}
+	if n == 0 {
+		i, err := choose("/LSP/CodeActions", titles)
+		if err != nil {
+			return err
+		}
+		if i < 0 {
+			return nil // window deleted
+		}
+		n = i + 1
+	}
+	if n < 1 || n > len(actions) {
+		return fmt.Errorf("code action %v out of range [1, %v]", n, len(actions))
+	}
+	return applyCodeAction(ctx, rc.server, doc, &actions[n-1], rc.menu)
+}
+
+// codeActionTitle returns the title of code action a followed by its kind.
+func codeActionTitle(a *protocol.CodeAction) string {
+	if a.Kind == "" {
+		return a.Title
+	}
+	return fmt.Sprintf("%v (%v)", a.Title, a.Kind)
 }
 
+// Hover prints the hover information of the symbol at the cursor
+// position as plain text.
 func (rc *RemoteCmd) Hover(ctx context.Context) error {
 	pos, _, err := text.Position(rc.win)
 	if err != nil {
@@ -203,7 +333,7 @@ func (rc *RemoteCmd) Hover(ctx context.Context) error {
 		return fmt.Errorf("no hover help available")
 	}
 
-	fmt.Fprintf(rc.Stdout, "%v\n", hov.Contents.Value)
+	fmt.Fprint(rc.Stdout, text.Documentation(hov.Contents.Value))
 
 	return nil
 }
@@ -222,7 +352,10 @@ func (rc *RemoteCmd) Implementation(ctx context.Context, print bool) error {
 	if len(loc) == 0 {
 		return fmt.Errorf("no implementations found")
 	}
-	return PrintLocations(rc.Stdout, loc)
+	if print || len(loc) > 1 {
+		return PrintLocations(rc.Stdout, loc)
+	}
+	return PlumbLocations(loc)
 }
 
 func (rc *RemoteCmd) References(ctx context.Context) error {
@@ -261,66 +394,7 @@ func (rc *RemoteCmd) Rename(ctx context.Context, newname string) error {
 	if err != nil {
 		return err
 	}
-	return editWorkspace(we, rc.menu)
-}
-
-func (rc *RemoteCmd) SignatureHelp(ctx context.Context) error {
-	pos, _, err := text.Position(rc.win)
-	if err != nil {
-		return err
-	}
-	sh, err := rc.server.SignatureHelp(ctx, &protocol.SignatureHelpParams{
-		TextDocumentPositionParams: *pos,
-	})
-	if err != nil {
-		return err
-	}
-	if sh == nil || len(sh.Signatures) == 0 {
-		return fmt.Errorf("no signature help available")
-	}
-	for _, sig := range sh.Signatures {
-		fmt.Fprintf(rc.Stdout, "%v\n", sig.Label)
-		fmt.Fprintf(rc.Stdout, "%v\n", sig.Documentation)
-	}
-	return nil
-}
-
-func (rc *RemoteCmd) DocumentSymbol(ctx context.Context) error {
-	basedir := "" // TODO
-
-	uri, _, err := text.DocumentURI(rc.win)
-	if err != nil {
-		return err
-	}
-
-	// TODO(fhs): DocumentSymbol request can return either a
-	// []DocumentSymbol (hierarchical) or []SymbolInformation (flat).
-	// We only handle the hierarchical type below.
-
-	// TODO(fhs): Make use of DocumentSymbol.Range to optionally filter out
-	// symbols that aren't within current cursor position?
-
-	syms, err := rc.server.DocumentSymbol(ctx, &protocol.DocumentSymbolParams{
-		TextDocument: protocol.TextDocumentIdentifier{
-			URI: uri,
-		},
-	})
-	if err != nil {
-		return err
-	}
-	if len(syms) == 0 {
-		return fmt.Errorf("no symbols found")
-	}
-	walkDocumentSymbols(syms, 0, func(s *protocol.DocumentSymbol, depth int) {
-		loc := &protocol.Location{
-			URI:   uri,
-			Range: s.SelectionRange,
-		}
-		indent := strings.Repeat(" ", depth)
-		fmt.Fprintf(rc.Stdout, "%v%v %v\n", indent, s.Name, s.Detail)
-		fmt.Fprintf(rc.Stdout, "%v %v\n", indent, lsp.LocationLink(loc, basedir))
-	})
-	return nil
+	return editWorkspace(ctx, we, rc.menu, rc.server)
 }
 
 func (rc *RemoteCmd) TypeDefinition(ctx context.Context, print bool) error {
@@ -400,46 +474,32 @@ func locationsFromDefinition(result *protocol.Or_Result_textDocument_definition)
 	return nil
 }
 
-func walkDocumentSymbols1(syms []protocol.DocumentSymbol, depth int, f func(s *protocol.DocumentSymbol, depth int)) {
-	for _, s := range syms {
-		f(&s, depth)
-		walkDocumentSymbols1(s.Children, depth+1, f)
+// locationsFromDeclaration converts an Or_textDocument_declaration
+// response to a flat []Location. The LSP spec allows the response to be a single
+// Location, a []Location (via Declaration = Or_Declaration), or []LocationLink.
+func locationsFromDeclaration(result *protocol.Or_textDocument_declaration) []protocol.Location {
+	if result == nil {
+		return nil
 	}
-}
-
-func walkDocumentSymbols(syms []interface{}, depth int, f func(s *protocol.DocumentSymbol, depth int)) {
-	for _, s := range syms {
-		switch val := s.(type) {
-		default:
-			log.Printf("unknown symbol type %T", val)
-
-		case protocol.DocumentSymbol:
-			f(&val, depth)
-			walkDocumentSymbols1(val.Children, depth+1, f)
-
-		// Workaround for the DocumentSymbol not being parsed by the auto-generated LSP definitions.
-		case map[string]interface{}:
-			ds, err := parseDocumentSymbol(val)
-			if err != nil {
-				log.Printf("failed to parse DocumentSymbols: %v\n", err)
-			} else {
-				f(ds, depth)
-				walkDocumentSymbols1(ds.Children, depth+1, f)
+	switch v := result.Value.(type) {
+	case protocol.Declaration:
+		switch lv := v.Value.(type) {
+		case protocol.Location:
+			return []protocol.Location{lv}
+		case []protocol.Location:
+			return lv
+		}
+	case []protocol.DeclarationLink:
+		locs := make([]protocol.Location, len(v))
+		for i, dl := range v {
+			locs[i] = protocol.Location{
+				URI:   dl.TargetURI,
+				Range: dl.TargetSelectionRange,
 			}
 		}
+		return locs
 	}
-}
-
-func parseDocumentSymbol(data map[string]interface{}) (*protocol.DocumentSymbol, error) {
-	b, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
-	}
-	var ds protocol.DocumentSymbol
-	if err := json.Unmarshal(b, &ds); err != nil {
-		return nil, err
-	}
-	return &ds, nil
+	return nil
 }
 
 func parseAcmeAddr(addr string) (filename string, q0 int, q1 int, err error) {
@@ -458,7 +518,7 @@ func parseAcmeAddr(addr string) (filename string, q0 int, q1 int, err error) {
 	}
 	q1 = q0
 	if len(f) > 1 {
-		q1, err = strconv.Atoi(strings.TrimPrefix(f[0], "#"))
+		q1, err = strconv.Atoi(strings.TrimPrefix(f[1], "#"))
 		if err != nil {
 			return "", -1, -1, fmt.Errorf("failed to parse q1 in $acmdaddr %q: %v", addr, err)
 		}
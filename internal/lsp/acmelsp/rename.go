@@ -0,0 +1,185 @@
+package acmelsp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/acmelsp/config"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// pendingRenameFile is the file in the acme-lsp cache directory where
+// the last previewed rename is saved until it's applied.
+const pendingRenameFile = "rename.json"
+
+// pendingRename is a previewed rename waiting to be applied.
+type pendingRename struct {
+	Edit *protocol.WorkspaceEdit
+
+	// Checksums of the files at the time of the preview,
+	// used to detect if they changed since then.
+	Checksums map[string][]byte
+}
+
+// RenamePreview prints the unified diff of the changes that renaming the
+// identifier at cursor position to newname would make, followed by the
+// files that would be created, renamed or deleted, without modifying
+// any file. The changes are saved so that they can be applied later by
+// ApplyRename.
+func (rc *RemoteCmd) RenamePreview(ctx context.Context, newname string) error {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return err
+	}
+	initres, err := rc.server.InitializeResult(ctx, &pos.TextDocument)
+	if err != nil {
+		return err
+	}
+	if lsp.ServerProvidesPrepareRename(&initres.Capabilities) {
+		res, err := rc.server.PrepareRename(ctx, &protocol.PrepareRenameParams{
+			TextDocumentPositionParams: *pos,
+		})
+		if err != nil {
+			return err
+		}
+		if res == nil {
+			return fmt.Errorf("cannot rename the symbol at the cursor position")
+		}
+	}
+	we, err := rc.server.Rename(ctx, &protocol.RenameParams{
+		NewName:                    newname,
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return err
+	}
+	if we == nil {
+		return fmt.Errorf("no changes")
+	}
+	changes, err := workspaceTextEdits(we)
+	if err != nil {
+		return err
+	}
+
+	pr := &pendingRename{
+		Edit:      we,
+		Checksums: make(map[string][]byte),
+	}
+	var uris []string
+	for uri := range changes {
+		uris = append(uris, string(uri))
+	}
+	sort.Strings(uris)
+	for _, uri := range uris {
+		fname := text.ToPath(protocol.DocumentURI(uri))
+		body, err := readFileBody(rc.menu, fname)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(body)
+		pr.Checksums[fname] = sum[:]
+		fmt.Fprint(rc.Stdout, text.Diff(fname, string(body), changes[protocol.DocumentURI(uri)]))
+	}
+	for _, dc := range we.DocumentChanges {
+		switch {
+		case dc.CreateFile != nil:
+			fmt.Fprintf(rc.Stdout, "create %v\n", text.ToPath(dc.CreateFile.URI))
+		case dc.RenameFile != nil:
+			fmt.Fprintf(rc.Stdout, "rename %v %v\n", text.ToPath(dc.RenameFile.OldURI), text.ToPath(dc.RenameFile.NewURI))
+		case dc.DeleteFile != nil:
+			fmt.Fprintf(rc.Stdout, "delete %v\n", text.ToPath(dc.DeleteFile.URI))
+		}
+	}
+	return savePendingRename(pr)
+}
+
+// ApplyRename applies the changes previewed by the last RenamePreview.
+// It fails if any of the files changed since the preview.
+func (rc *RemoteCmd) ApplyRename(ctx context.Context) error {
+	path, err := config.CacheFilePath(pendingRenameFile)
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no rename to apply; preview one with rn -n first")
+	}
+	if err != nil {
+		return err
+	}
+	var pr pendingRename
+	if err := json.Unmarshal(b, &pr); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", path, err)
+	}
+	for fname, want := range pr.Checksums {
+		body, err := readFileBody(rc.menu, fname)
+		if err != nil {
+			return err
+		}
+		if sum := sha256.Sum256(body); string(sum[:]) != string(want) {
+			return fmt.Errorf("%v changed since the rename was previewed", fname)
+		}
+	}
+	if err := openFiles(rc.menu, pr.Checksums); err != nil {
+		return err
+	}
+	if err := editWorkspace(ctx, pr.Edit, rc.menu, rc.server); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func savePendingRename(pr *pendingRename) error {
+	path, err := config.CacheFilePath(pendingRenameFile)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(pr)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0600)
+}
+
+// openFiles opens the files in the editor that aren't open yet, so that
+// they can be edited.
+func openFiles(menu text.Menu, files map[string][]byte) error {
+	for fname := range files {
+		f, err := menu.Open(fname)
+		if err == nil {
+			f.CloseFiles()
+			continue
+		}
+		// Create loads the file in a new window.
+		if err := menu.Create(fname); err != nil {
+			return fmt.Errorf("failed to open window %v: %v", fname, err)
+		}
+	}
+	return nil
+}
+
+// readFileBody returns the text of file fname as seen by the editor,
+// or as saved on disk if it isn't open in the editor.
+func readFileBody(menu text.Menu, fname string) ([]byte, error) {
+	f, err := menu.Open(fname)
+	if err != nil {
+		b, rerr := os.ReadFile(fname)
+		if rerr != nil {
+			return nil, fmt.Errorf("failed to open window %v: %v; failed to read it: %v", fname, err, rerr)
+		}
+		return b, nil
+	}
+	defer f.CloseFiles()
+	r, err := f.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,258 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// SignatureHelp prints the signatures of the function call at the
+// cursor position, the active one first with its active parameter
+// marked and the documentation of its parameters.
+func (rc *RemoteCmd) SignatureHelp(ctx context.Context) error {
+	_, err := rc.signatureHelp(ctx, nil)
+	return err
+}
+
+// signatureHelp is like SignatureHelp but also returns the signature
+// help printed. Active is the signature help currently shown to the
+// user, if any, in which case the request is a retrigger.
+func (rc *RemoteCmd) signatureHelp(ctx context.Context, active *protocol.SignatureHelp) (*protocol.SignatureHelp, error) {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return nil, err
+	}
+	sh, err := rc.server.SignatureHelp(ctx, &protocol.SignatureHelpParams{
+		Context:                    rc.signatureHelpContext(ctx, &pos.TextDocument, active),
+		TextDocumentPositionParams: *pos,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sh == nil || len(sh.Signatures) == 0 {
+		return nil, fmt.Errorf("no signature help available")
+	}
+	fmt.Fprint(rc.Stdout, formatSignatureHelp(sh))
+	return sh, nil
+}
+
+// signatureHelpContext returns the context of a signature help request
+// made at the cursor position. It's triggered by a character if the
+// character before the cursor is one of the trigger characters of the
+// server (or retrigger characters, if active is not nil).
+func (rc *RemoteCmd) signatureHelpContext(ctx context.Context, doc *protocol.TextDocumentIdentifier, active *protocol.SignatureHelp) *protocol.SignatureHelpContext {
+	sc := &protocol.SignatureHelpContext{
+		TriggerKind:         protocol.SigInvoked,
+		IsRetrigger:         active != nil,
+		ActiveSignatureHelp: active,
+	}
+	if active != nil {
+		sc.TriggerKind = protocol.SigContentChange
+	}
+	initres, err := rc.server.InitializeResult(ctx, doc)
+	if err != nil {
+		log.Printf("failed to get initialize result: %v", err)
+		return sc
+	}
+	opts := initres.Capabilities.SignatureHelpProvider
+	if opts == nil {
+		return sc
+	}
+	body, err := readBody(rc.win)
+	if err != nil {
+		return sc
+	}
+	q0, _, err := rc.win.CurrentAddr()
+	if err != nil || q0 < 1 || q0 > len(body) {
+		return sc
+	}
+	c := string(body[q0-1])
+	if containsString(opts.TriggerCharacters, c) || (active != nil && containsString(opts.RetriggerCharacters, c)) {
+		sc.TriggerKind = protocol.SigTriggerCharacter
+		sc.TriggerCharacter = c
+	}
+	return sc
+}
+
+func containsString(a []string, s string) bool {
+	for _, t := range a {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSignatureHelp formats signature help sh as plain text. The
+// active signature comes first, with its active parameter enclosed in
+// «», followed by its documentation and the documentation of each of
+// its parameters. When there are overloads, the signatures are numbered
+// in the order given by the server and the other overloads are listed
+// at the end.
+func formatSignatureHelp(sh *protocol.SignatureHelp) string {
+	active := int(sh.ActiveSignature)
+	if active >= len(sh.Signatures) {
+		active = 0
+	}
+	sig := &sh.Signatures[active]
+	overloaded := len(sh.Signatures) > 1
+
+	var sb strings.Builder
+	if overloaded {
+		fmt.Fprintf(&sb, "%v. ", active+1)
+	}
+	// A signature's activeParameter of 0 is indistinguishable from
+	// an absent one, which is why decodeSignatureHelp moves the
+	// activeParameter of the active signature into sh.
+	param := int(sh.ActiveParameter)
+	if sig.ActiveParameter != 0 {
+		param = int(sig.ActiveParameter)
+	}
+	label := []rune(sig.Label)
+	ranges := parameterRanges(sig)
+	if param < len(ranges) && ranges[param][0] >= 0 {
+		q0, q1 := ranges[param][0], ranges[param][1]
+		fmt.Fprintf(&sb, "%v«%v»%v\n", string(label[:q0]), string(label[q0:q1]), string(label[q1:]))
+	} else {
+		fmt.Fprintf(&sb, "%v\n", sig.Label)
+	}
+	if sig.Documentation != nil {
+		if doc := text.Documentation(sig.Documentation.Value); doc != "" {
+			fmt.Fprintf(&sb, "\n%v", doc)
+		}
+	}
+	sep := "\n"
+	for i, p := range sig.Parameters {
+		if p.Documentation == nil {
+			continue
+		}
+		doc := text.Documentation(p.Documentation.Value)
+		if doc == "" {
+			continue
+		}
+		name := parameterName(&p)
+		if ranges[i][0] >= 0 {
+			name = string(label[ranges[i][0]:ranges[i][1]])
+		}
+		fmt.Fprintf(&sb, "%v%v\n", sep, name)
+		sep = ""
+		for _, l := range strings.SplitAfter(strings.TrimSuffix(doc, "\n"), "\n") {
+			if strings.TrimSpace(l) == "" {
+				sb.WriteString(l)
+				continue
+			}
+			fmt.Fprintf(&sb, "\t%v", l)
+		}
+		sb.WriteString("\n")
+	}
+	if overloaded {
+		sb.WriteString("\n")
+		for i, s := range sh.Signatures {
+			if i != active {
+				fmt.Fprintf(&sb, "%v. %v\n", i+1, s.Label)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// parameterRanges returns the range of runes within the label of sig
+// covered by each of its parameters, or {-1, -1} if the parameter
+// couldn't be found in the label.
+func parameterRanges(sig *protocol.SignatureInformation) [][2]int {
+	label := []rune(sig.Label)
+	ranges := make([][2]int, len(sig.Parameters))
+
+	// Parameters are searched after the opening parenthesis
+	// so that they aren't found within the function name.
+	from := strings.IndexRune(sig.Label, '(')
+	if from < 0 {
+		from = 0
+	} else {
+		from = len([]rune(sig.Label[:from]))
+	}
+	for i := range sig.Parameters {
+		ranges[i] = [2]int{-1, -1}
+		p := &sig.Parameters[i]
+		if off, ok := parameterOffsets(p); ok {
+			q0, q1 := text.RuneColumn(label, off[0]), text.RuneColumn(label, off[1])
+			if q0 <= q1 {
+				ranges[i] = [2]int{q0, q1}
+			}
+			continue
+		}
+		name := []rune(parameterName(p))
+		if len(name) == 0 {
+			continue
+		}
+		if j := strings.Index(string(label[from:]), string(name)); j >= 0 {
+			q0 := from + len([]rune(string(label[from:])[:j]))
+			ranges[i] = [2]int{q0, q0 + len(name)}
+			from = q0 + len(name)
+		}
+	}
+	return ranges
+}
+
+// parameterName returns the label of parameter p if it's a string.
+func parameterName(p *protocol.ParameterInformation) string {
+	b, err := json.Marshal(p.Label)
+	if err != nil {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// parameterOffsets returns the start and end offsets, in UTF-16 code
+// units, of parameter p within the label of its signature if its label
+// is given as offsets.
+func parameterOffsets(p *protocol.ParameterInformation) ([2]int, bool) {
+	var off [2]int
+	b, err := json.Marshal(p.Label)
+	if err != nil {
+		return off, false
+	}
+	if err := json.Unmarshal(b, &off); err != nil {
+		return off, false
+	}
+	return off, true
+}
+
+// decodeSignatureHelp decodes the JSON signature help b. The
+// activeParameter of a signature overrides the one of the signature
+// help, even when it's 0, but protocol.SignatureInformation can't tell
+// 0 from an absent value. So the activeParameter present in the active
+// signature replaces the one of the signature help.
+func decodeSignatureHelp(b []byte) (*protocol.SignatureHelp, error) {
+	var sh *protocol.SignatureHelp
+	if err := json.Unmarshal(b, &sh); err != nil || sh == nil {
+		return nil, err
+	}
+	var active struct {
+		Signatures []struct {
+			ActiveParameter *uint32 `json:"activeParameter"`
+		} `json:"signatures"`
+	}
+	if err := json.Unmarshal(b, &active); err != nil {
+		return nil, err
+	}
+	i := int(sh.ActiveSignature)
+	if i >= len(active.Signatures) {
+		i = 0
+	}
+	if i < len(active.Signatures) {
+		if p := active.Signatures[i].ActiveParameter; p != nil {
+			sh.ActiveParameter = *p
+		}
+	}
+	return sh, nil
+}
@@ -0,0 +1,506 @@
+package acmelsp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"9fans.net/acme-lsp/internal/lsp"
+	"9fans.net/acme-lsp/internal/lsp/proxy"
+	"9fans.net/acme-lsp/internal/lsp/text"
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// DocumentSymbol prints the symbols of the current file as an indented
+// tree, each followed by its location. The location has the absolute
+// path of the file, so that it can be plumbed from any window.
+func (rc *RemoteCmd) DocumentSymbol(ctx context.Context) error {
+	uri, _, err := text.DocumentURI(rc.win)
+	if err != nil {
+		return err
+	}
+	syms, err := rc.documentSymbols(ctx, uri)
+	if err != nil {
+		return err
+	}
+	walkDocumentSymbols(syms, 0, func(s *protocol.DocumentSymbol, depth int) {
+		loc := &protocol.Location{
+			URI:   uri,
+			Range: s.SelectionRange,
+		}
+		indent := strings.Repeat(" ", depth)
+		fmt.Fprintf(rc.Stdout, "%v%v %v\n", indent, s.Name, s.Detail)
+		fmt.Fprintf(rc.Stdout, "%v %v\n", indent, lsp.LocationLink(loc, ""))
+	})
+	return nil
+}
+
+// EnclosingSymbols prints the chain of symbols enclosing the cursor
+// position, from the outermost to the innermost, separated by " > ".
+func (rc *RemoteCmd) EnclosingSymbols(ctx context.Context) error {
+	pos, _, err := text.Position(rc.win)
+	if err != nil {
+		return err
+	}
+	syms, err := rc.documentSymbols(ctx, pos.TextDocument.URI)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, s := range enclosingSymbols(syms, pos.Position) {
+		names = append(names, s.Name)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no symbol encloses the cursor position")
+	}
+	fmt.Fprintf(rc.Stdout, "%v\n", strings.Join(names, " > "))
+	return nil
+}
+
+// documentSymbols returns the symbols of document uri as a tree.
+func (rc *RemoteCmd) documentSymbols(ctx context.Context, uri protocol.DocumentURI) ([]protocol.DocumentSymbol, error) {
+	result, err := rc.server.DocumentSymbol(ctx, &protocol.DocumentSymbolParams{
+		TextDocument: protocol.TextDocumentIdentifier{
+			URI: uri,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	syms := documentSymbolTree(result)
+	if len(syms) == 0 {
+		return nil, fmt.Errorf("no symbols found")
+	}
+	return syms, nil
+}
+
+// enclosingSymbols returns the chain of symbols in the tree syms whose
+// range contains pos, from the outermost to the innermost.
+func enclosingSymbols(syms []protocol.DocumentSymbol, pos protocol.Position) []*protocol.DocumentSymbol {
+	var chain []*protocol.DocumentSymbol
+	for {
+		var inner *protocol.DocumentSymbol
+		for i := range syms {
+			r := syms[i].Range
+			if lsp.ComparePositions(r.Start, pos) <= 0 && lsp.ComparePositions(pos, r.End) <= 0 {
+				inner = &syms[i]
+				break
+			}
+		}
+		if inner == nil {
+			return chain
+		}
+		chain = append(chain, inner)
+		syms = inner.Children
+	}
+}
+
+func walkDocumentSymbols(syms []protocol.DocumentSymbol, depth int, f func(s *protocol.DocumentSymbol, depth int)) {
+	for i := range syms {
+		f(&syms[i], depth)
+		walkDocumentSymbols(syms[i].Children, depth+1, f)
+	}
+}
+
+// documentSymbolTree converts the result of a DocumentSymbol request to
+// a tree of DocumentSymbols. The result can be either a hierarchical
+// []DocumentSymbol or a flat []SymbolInformation, in which case the
+// tree is rebuilt from the nesting of the symbol ranges.
+func documentSymbolTree(result []interface{}) []protocol.DocumentSymbol {
+	var (
+		syms []protocol.DocumentSymbol
+		flat bool
+	)
+	for _, s := range result {
+		switch val := s.(type) {
+		default:
+			log.Printf("unknown symbol type %T", val)
+
+		case protocol.DocumentSymbol:
+			syms = append(syms, val)
+
+		case protocol.SymbolInformation:
+			syms = append(syms, symbolInformationToDocumentSymbol(&val))
+			flat = true
+
+		// Workaround for the DocumentSymbol not being parsed by the auto-generated LSP definitions.
+		case map[string]interface{}:
+			if _, ok := val["location"]; ok {
+				var si protocol.SymbolInformation
+				if err := parseSymbol(val, &si); err != nil {
+					log.Printf("failed to parse SymbolInformation: %v\n", err)
+					continue
+				}
+				syms = append(syms, symbolInformationToDocumentSymbol(&si))
+				flat = true
+				continue
+			}
+			var ds protocol.DocumentSymbol
+			if err := parseSymbol(val, &ds); err != nil {
+				log.Printf("failed to parse DocumentSymbols: %v\n", err)
+				continue
+			}
+			syms = append(syms, ds)
+		}
+	}
+	if flat {
+		return nestSymbols(syms)
+	}
+	return syms
+}
+
+func parseSymbol(data map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func symbolInformationToDocumentSymbol(si *protocol.SymbolInformation) protocol.DocumentSymbol {
+	return protocol.DocumentSymbol{
+		Name:           si.Name,
+		Kind:           si.Kind,
+		Tags:           si.Tags,
+		Range:          si.Location.Range,
+		SelectionRange: si.Location.Range,
+	}
+}
+
+// nestSymbols turns the flat list of symbols syms into a tree where
+// each symbol is a child of the smallest symbol whose range contains
+// its range.
+func nestSymbols(syms []protocol.DocumentSymbol) []protocol.DocumentSymbol {
+	sort.SliceStable(syms, func(i, j int) bool {
+		a, b := syms[i].Range, syms[j].Range
+		if c := lsp.ComparePositions(a.Start, b.Start); c != 0 {
+			return c < 0
+		}
+		return lsp.ComparePositions(a.End, b.End) > 0
+	})
+	var nest func(i int, end *protocol.Position) ([]protocol.DocumentSymbol, int)
+	nest = func(i int, end *protocol.Position) ([]protocol.DocumentSymbol, int) {
+		var tree []protocol.DocumentSymbol
+		for i < len(syms) && (end == nil || lsp.ComparePositions(syms[i].Range.End, *end) <= 0) {
+			s := syms[i]
+			s.Children, i = nest(i+1, &s.Range.End)
+			tree = append(tree, s)
+		}
+		return tree, i
+	}
+	tree, _ := nest(0, nil)
+	return tree
+}
+
+// symbolKindNames maps symbol kinds to the names shown by WorkspaceSymbol.
+var symbolKindNames = map[protocol.SymbolKind]string{
+	protocol.File:          "file",
+	protocol.Module:        "module",
+	protocol.Namespace:     "namespace",
+	protocol.Package:       "package",
+	protocol.Class:         "class",
+	protocol.Method:        "method",
+	protocol.Property:      "property",
+	protocol.Field:         "field",
+	protocol.Constructor:   "constructor",
+	protocol.Enum:          "enum",
+	protocol.Interface:     "interface",
+	protocol.Function:      "function",
+	protocol.Variable:      "variable",
+	protocol.Constant:      "constant",
+	protocol.String:        "string",
+	protocol.Number:        "number",
+	protocol.Boolean:       "boolean",
+	protocol.Array:         "array",
+	protocol.Object:        "object",
+	protocol.Key:           "key",
+	protocol.Null:          "null",
+	protocol.EnumMember:    "enummember",
+	protocol.Struct:        "struct",
+	protocol.Event:         "event",
+	protocol.Operator:      "operator",
+	protocol.TypeParameter: "typeparameter",
+}
+
+// symbolKindGroups are shorthands for groups of symbol kinds accepted
+// by ParseSymbolKinds.
+var symbolKindGroups = map[string][]protocol.SymbolKind{
+	"func":  {protocol.Function, protocol.Method, protocol.Constructor},
+	"type":  {protocol.Class, protocol.Interface, protocol.Struct, protocol.Enum, protocol.TypeParameter},
+	"var":   {protocol.Variable, protocol.Field, protocol.Property},
+	"const": {protocol.Constant, protocol.EnumMember},
+}
+
+// symbolKinds returns all the symbol kinds, in increasing order.
+func symbolKinds() []protocol.SymbolKind {
+	var kinds []protocol.SymbolKind
+	for k := range symbolKindNames {
+		kinds = append(kinds, k)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+func symbolKindName(k protocol.SymbolKind) string {
+	if name, ok := symbolKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("kind%v", uint32(k))
+}
+
+// ParseSymbolKinds parses a comma-separated list of symbol kind names
+// (e.g. "function,struct") or shorthands for groups of kinds ("func",
+// "type", "var" and "const").
+func ParseSymbolKinds(s string) ([]protocol.SymbolKind, error) {
+	var kinds []protocol.SymbolKind
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if g, ok := symbolKindGroups[name]; ok {
+			kinds = append(kinds, g...)
+			continue
+		}
+		found := false
+		for k, n := range symbolKindNames {
+			if n == name {
+				kinds = append(kinds, k)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown symbol kind %q", name)
+		}
+	}
+	return kinds, nil
+}
+
+// WorkspaceSymbol prints the symbols matching query in the workspace,
+// best matches first, with their kind, container and location. Only the
+// server with key serverKey is queried unless serverKey is empty, and
+// only the symbols of the given kinds are printed unless kinds is empty.
+// At most limit symbols are printed if limit is positive.
+func WorkspaceSymbol(ctx context.Context, w io.Writer, server proxy.Server, query, serverKey string, kinds []protocol.SymbolKind, limit int) error {
+	symbols, err := server.WorkspaceSymbols(ctx, &proxy.WorkspaceSymbolsParams{
+		WorkspaceSymbolParams: protocol.WorkspaceSymbolParams{
+			Query: query,
+		},
+		ServerKey: serverKey,
+		Kinds:     kinds,
+		Limit:     limit,
+	})
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		wd = ""
+	}
+	for _, s := range symbols {
+		name := s.Name
+		if s.ContainerName != "" {
+			name = s.ContainerName + "." + name
+		}
+		fmt.Fprintf(w, "%v %v\t%v:%s\n", symbolKindName(s.Kind), name, lsp.LocationLink(&s.Location, wd), getLine(text.ToPath(s.Location.URI), int(s.Location.Range.Start.Line+1)))
+	}
+	return nil
+}
+
+func containsSymbolKind(kinds []protocol.SymbolKind, k protocol.SymbolKind) bool {
+	for _, kind := range kinds {
+		if kind == k {
+			return true
+		}
+	}
+	return false
+}
+
+// workspaceSymbol is a workspace symbol returned by a server. The
+// servers supporting it can return symbols without a range, which are
+// resolved only when they are shown.
+type workspaceSymbol struct {
+	protocol.SymbolInformation
+
+	// unresolved is the symbol to resolve with client if it was
+	// returned without a range.
+	unresolved *protocol.WorkspaceSymbol
+	client     *Client
+}
+
+// Symbol queries the workspace symbols matching params.Query. Symbols
+// returned without a range are resolved if the server supports it.
+func (s *Client) Symbol(ctx context.Context, params *protocol.WorkspaceSymbolParams) ([]protocol.SymbolInformation, error) {
+	symbols, err := s.workspaceSymbols(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	return resolveWorkspaceSymbols(ctx, symbols)
+}
+
+// workspaceSymbols queries the workspace symbols matching params.Query
+// without resolving them.
+func (s *Client) workspaceSymbols(ctx context.Context, params *protocol.WorkspaceSymbolParams) ([]workspaceSymbol, error) {
+	if s.initializeResult == nil || !lsp.ServerProvidesWorkspaceSymbolResolve(&s.initializeResult.Capabilities) {
+		result, err := s.Server.Symbol(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		symbols := make([]workspaceSymbol, len(result))
+		for i, si := range result {
+			symbols[i] = workspaceSymbol{SymbolInformation: si, client: s}
+		}
+		return symbols, nil
+	}
+
+	// The result can contain WorkspaceSymbols whose location only has
+	// a URI, which can't be decoded as SymbolInformation.
+	result, err := s.Server.NonstandardRequest(ctx, "workspace/symbol", params)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace symbols: %v", err)
+	}
+	var symbols []workspaceSymbol
+	for _, item := range items {
+		var loc struct {
+			Location struct {
+				URI   protocol.DocumentURI `json:"uri"`
+				Range *protocol.Range      `json:"range"`
+			} `json:"location"`
+		}
+		if err := json.Unmarshal(item, &loc); err != nil {
+			return nil, fmt.Errorf("failed to parse workspace symbol: %v", err)
+		}
+		sym := workspaceSymbol{client: s}
+		if loc.Location.Range == nil {
+			var ws protocol.WorkspaceSymbol
+			if err := json.Unmarshal(item, &ws); err != nil {
+				return nil, fmt.Errorf("failed to parse workspace symbol: %v", err)
+			}
+			sym.unresolved = &ws
+			sym.BaseSymbolInformation = ws.BaseSymbolInformation
+			sym.Location.URI = loc.Location.URI
+		} else if err := json.Unmarshal(item, &sym.SymbolInformation); err != nil {
+			return nil, fmt.Errorf("failed to parse workspace symbol: %v", err)
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols, nil
+}
+
+// resolveWorkspaceSymbols resolves the symbols returned without a range.
+// The symbols that fail to resolve are left out.
+func resolveWorkspaceSymbols(ctx context.Context, symbols []workspaceSymbol) ([]protocol.SymbolInformation, error) {
+	var result []protocol.SymbolInformation
+	for _, sym := range symbols {
+		if sym.unresolved == nil {
+			result = append(result, sym.SymbolInformation)
+			continue
+		}
+		resolved, err := sym.client.Server.ResolveWorkspaceSymbol(ctx, sym.unresolved)
+		if err != nil {
+			log.Printf("failed to resolve workspace symbol %v: %v", sym.Name, err)
+			continue
+		}
+		b, err := json.Marshal(resolved)
+		if err != nil {
+			return nil, err
+		}
+		var si protocol.SymbolInformation
+		if err := json.Unmarshal(b, &si); err != nil {
+			return nil, fmt.Errorf("failed to parse workspace symbol: %v", err)
+		}
+		result = append(result, si)
+	}
+	return result, nil
+}
+
+// selectWorkspaceSymbols returns the symbols of the kinds given in params,
+// deduplicated and ranked by how well they match the query, up to the
+// limit given in params.
+func selectWorkspaceSymbols(params *proxy.WorkspaceSymbolsParams, symbols []workspaceSymbol) []workspaceSymbol {
+	if len(params.Kinds) > 0 {
+		var filtered []workspaceSymbol
+		for _, s := range symbols {
+			if containsSymbolKind(params.Kinds, s.Kind) {
+				filtered = append(filtered, s)
+			}
+		}
+		symbols = filtered
+	}
+	symbols = rankSymbols(params.WorkspaceSymbolParams.Query, dedupSymbols(symbols))
+	if params.Limit > 0 && len(symbols) > params.Limit {
+		symbols = symbols[:params.Limit]
+	}
+	return symbols
+}
+
+// dedupSymbols removes the symbols found more than once, for example
+// by two servers handling the same files.
+func dedupSymbols(symbols []workspaceSymbol) []workspaceSymbol {
+	type key struct {
+		name string
+		kind protocol.SymbolKind
+		uri  protocol.DocumentURI
+		pos  protocol.Position
+	}
+	seen := make(map[key]bool)
+	var result []workspaceSymbol
+	for _, s := range symbols {
+		k := key{s.Name, s.Kind, s.Location.URI, s.Location.Range.Start}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		result = append(result, s)
+	}
+	return result
+}
+
+// rankSymbols sorts symbols by how well their name matches query:
+// exact matches first, then prefix matches and substring matches, with
+// case-sensitive matches before case-insensitive ones. Symbols matching
+// equally well keep the order given by the servers.
+func rankSymbols(query string, symbols []workspaceSymbol) []workspaceSymbol {
+	rank := func(s *workspaceSymbol) int {
+		// Some servers qualify the name (e.g. "Type.Method").
+		r := symbolMatchRank(query, s.Name)
+		if i := strings.LastIndex(s.Name, "."); i >= 0 {
+			r = min(r, symbolMatchRank(query, s.Name[i+1:]))
+		}
+		return r
+	}
+	sort.SliceStable(symbols, func(i, j int) bool {
+		return rank(&symbols[i]) < rank(&symbols[j])
+	})
+	return symbols
+}
+
+func symbolMatchRank(query, name string) int {
+	lq, ln := strings.ToLower(query), strings.ToLower(name)
+	switch {
+	case name == query:
+		return 0
+	case ln == lq:
+		return 1
+	case strings.HasPrefix(name, query):
+		return 2
+	case strings.HasPrefix(ln, lq):
+		return 3
+	case strings.Contains(name, query):
+		return 4
+	case strings.Contains(ln, lq):
+		return 5
+	}
+	return 6
+}
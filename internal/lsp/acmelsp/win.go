@@ -0,0 +1,79 @@
+package acmelsp
+
+import (
+	"fmt"
+
+	"9fans.net/acme-lsp/internal/acme"
+	"9fans.net/acme-lsp/internal/acmeutil"
+)
+
+// openWin returns the acme window named name, creating it if necessary.
+func openWin(name string) (*acmeutil.Win, error) {
+	w, err := acmeutil.Hijack(name)
+	if err != nil {
+		w, err = acmeutil.NewWin()
+		if err != nil {
+			return nil, err
+		}
+		w.Name(name)
+	}
+	return w, nil
+}
+
+// writeWin replaces the body of the acme window named name with body.
+func writeWin(name, body string) error {
+	w, err := openWin(name)
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+
+	return setWinBody(w, body)
+}
+
+// setWinBody replaces the body of the acme window w with body and marks
+// the window clean.
+func setWinBody(w *acmeutil.Win, body string) error {
+	w.Clear()
+	if _, err := w.Write("body", []byte(body)); err != nil {
+		return err
+	}
+	return w.Ctl("clean")
+}
+
+// appendWin appends text to the body of the acme window named name.
+func appendWin(name, text string) error {
+	w, err := openWin(name)
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+
+	if _, err := w.Write("body", []byte(text)); err != nil {
+		return err
+	}
+	return w.Ctl("clean")
+}
+
+// deleteWin deletes the acme windows named name, if any.
+func deleteWin(name string) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		if info.Name != name {
+			continue
+		}
+		w, err := acmeutil.OpenWin(info.ID)
+		if err != nil {
+			return err
+		}
+		err = w.Del(true)
+		w.CloseFiles()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -38,6 +38,20 @@ Acme-lsp watches for files created (New), loaded (Get), saved (Put), or
 deleted (Del) in acme, and tells the LSP server about these changes. The
 LSP server in turn responds by sending diagnostics information (compiler
 errors, lint errors, etc.) which are shown in a "/LSP/Diagnostics" window.
+Each diagnostic is shown on a line starting with its location, relative
+to the nearest workspace directory, followed by its severity (E, W, I or
+H for errors, warnings, information and hints), source, code and message.
+The diagnostics shown can be filtered by severity, source, message and
+path with the DiagnosticsFilter configuration option, globally or for
+each server. Executing Errors, Warnings or All in the tag of the window
+shows only errors, errors and warnings, or all of these diagnostics.
+Executing a line of the window with button 2, or executing Fix in the tag
+with dot on a line, applies a quick fix for the diagnostic on that line.
+If the server offers several quick fixes, they are listed in a
+"/LSP/QuickFixes" window where the one to apply is chosen.
+The number of errors and warnings of a file, as in "E:3 W:1", is also
+shown after the vertical bar in the tag of its window, and removed when
+the file has none. The rest of the text after the vertical bar is kept.
 Also, when Put is executed in an acme window, acme-lsp will organize
 import paths in the window and format it by default. This behavior can
 be changed by the FormatOnPut and CodeActionsOnPut configuration options.
@@ -116,9 +116,56 @@ func sendParseError(ctx context.Context, conn *jsonrpc2.Conn, id jsonrpc2.ID, er
 type ExecuteCommandOnDocumentParams struct {
 	TextDocument         protocol.TextDocumentIdentifier
 	ExecuteCommandParams protocol.ExecuteCommandParams
+
+	// ShowMessages is true if the messages sent by the server with
+	// window/showMessage while it executes the command are shown in
+	// the /LSP/Messages window, as for code lens commands.
+	ShowMessages bool
+}
+
+// ResolveCompletionItemOnDocumentParams contains a completion item and
+// the document it was completed in, which selects the server resolving it.
+type ResolveCompletionItemOnDocumentParams struct {
+	TextDocument   protocol.TextDocumentIdentifier
+	CompletionItem protocol.CompletionItem
+}
+
+type ExecuteCommandOnServerParams struct {
+	// ServerKey is the key in the configuration of the server
+	// executing the command.
+	ServerKey            string
+	ExecuteCommandParams protocol.ExecuteCommandParams
+}
+
+// ServerCommands lists the commands a server can execute.
+type ServerCommands struct {
+	ServerKey string
+	Commands  []string
+}
+
+// DiagnosticsParams selects the documents whose diagnostics are returned.
+type DiagnosticsParams struct {
+	// TextDocument is the document whose diagnostics are returned.
+	// The diagnostics of all documents are returned if its URI is empty.
+	TextDocument protocol.TextDocumentIdentifier
 }
 
 type SyncDocumentParams struct {
 	TextDocument protocol.TextDocumentIdentifier
 	Content      string
 }
+
+type WorkspaceSymbolsParams struct {
+	WorkspaceSymbolParams protocol.WorkspaceSymbolParams
+
+	// ServerKey is the key in the configuration of the server to query.
+	// All servers are queried if it's empty.
+	ServerKey string
+
+	// Kinds are the kinds of the symbols returned. Symbols of all kinds
+	// are returned if it's empty.
+	Kinds []protocol.SymbolKind
+
+	// Limit is the maximum number of symbols returned, if positive.
+	Limit int
+}
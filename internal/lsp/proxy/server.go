@@ -10,7 +10,7 @@ import (
 )
 
 // Version is used to detect if acme-lsp and L are speaking the same protocol.
-const Version = 1
+const Version = 6
 
 // Server implements a subset of an LSP protocol server as defined by protocol.Server and
 // some custom acme-lsp specific methods.
@@ -33,10 +33,33 @@ type Server interface {
 	// multiple ExecuteCommand request to the right server.
 	ExecuteCommandOnDocument(context.Context, *ExecuteCommandOnDocumentParams) (interface{}, error)
 
+	// ExecuteCommandOnServer is the same as ExecuteCommand, but the command is executed
+	// by the server with the key given in params.
+	ExecuteCommandOnServer(context.Context, *ExecuteCommandOnServerParams) (interface{}, error)
+
+	// ResolveCompletionItemOnDocument is the same as ResolveCompletionItem,
+	// but params contain the TextDocumentIdentifier of the document the item
+	// was completed in so that the item is resolved by the right server.
+	ResolveCompletionItemOnDocument(context.Context, *ResolveCompletionItemOnDocumentParams) (*protocol.CompletionItem, error)
+
+	// Commands returns the commands each server can execute.
+	Commands(context.Context) ([]ServerCommands, error)
+
 	// SyncDocument sends a DidOpen or DidChange notification depending on if the document
 	// is already open.
 	SyncDocument(context.Context, *SyncDocumentParams) error
 
+	// WorkspaceSymbols is the same as Symbol, but it can query a single
+	// server and filter the symbols by kind. The symbols returned by all
+	// the servers queried are deduplicated and ranked by how well they
+	// match the query, and only the best ones up to the limit are
+	// resolved and returned.
+	WorkspaceSymbols(context.Context, *WorkspaceSymbolsParams) ([]protocol.SymbolInformation, error)
+
+	// Diagnostics returns the diagnostics last published by the servers
+	// that pass the diagnostics filters of the configuration, sorted by URI.
+	Diagnostics(context.Context, *DiagnosticsParams) ([]protocol.PublishDiagnosticsParams, error)
+
 	protocol.Server
 	//DidChange(context.Context, *protocol.DidChangeTextDocumentParams) error
 	//DidChangeWorkspaceFolders(context.Context, *protocol.DidChangeWorkspaceFoldersParams) error
@@ -79,6 +102,26 @@ func serverDispatch(ctx context.Context, server Server, conn *jsonrpc2.Conn, r *
 		resp, err := server.ExecuteCommandOnDocument(ctx, &params)
 		return true, reply(ctx, conn, r.ID, resp, err)
 
+	case "acme-lsp/executeCommandOnServer": // req
+		var params ExecuteCommandOnServerParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			return true, sendParseError(ctx, conn, r.ID, err)
+		}
+		resp, err := server.ExecuteCommandOnServer(ctx, &params)
+		return true, reply(ctx, conn, r.ID, resp, err)
+
+	case "acme-lsp/resolveCompletionItemOnDocument": // req
+		var params ResolveCompletionItemOnDocumentParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			return true, sendParseError(ctx, conn, r.ID, err)
+		}
+		resp, err := server.ResolveCompletionItemOnDocument(ctx, &params)
+		return true, reply(ctx, conn, r.ID, resp, err)
+
+	case "acme-lsp/commands": // req
+		resp, err := server.Commands(ctx)
+		return true, reply(ctx, conn, r.ID, resp, err)
+
 	case "acme-lsp/syncDocument": // notif
 		var params SyncDocumentParams
 		if err := json.Unmarshal(*r.Params, &params); err != nil {
@@ -87,6 +130,22 @@ func serverDispatch(ctx context.Context, server Server, conn *jsonrpc2.Conn, r *
 		err := server.SyncDocument(ctx, &params)
 		return true, err
 
+	case "acme-lsp/workspaceSymbols": // req
+		var params WorkspaceSymbolsParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			return true, sendParseError(ctx, conn, r.ID, err)
+		}
+		resp, err := server.WorkspaceSymbols(ctx, &params)
+		return true, reply(ctx, conn, r.ID, resp, err)
+
+	case "acme-lsp/diagnostics": // req
+		var params DiagnosticsParams
+		if err := json.Unmarshal(*r.Params, &params); err != nil {
+			return true, sendParseError(ctx, conn, r.ID, err)
+		}
+		resp, err := server.Diagnostics(ctx, &params)
+		return true, reply(ctx, conn, r.ID, resp, err)
+
 	default:
 		return false, nil
 	}
@@ -132,10 +191,50 @@ func (s *serverDispatcher) ExecuteCommandOnDocument(ctx context.Context, params
 	return result, nil
 }
 
+func (s *serverDispatcher) ExecuteCommandOnServer(ctx context.Context, params *ExecuteCommandOnServerParams) (interface{}, error) {
+	var result interface{}
+	if err := s.Conn.Call(ctx, "acme-lsp/executeCommandOnServer", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *serverDispatcher) ResolveCompletionItemOnDocument(ctx context.Context, params *ResolveCompletionItemOnDocumentParams) (*protocol.CompletionItem, error) {
+	var result protocol.CompletionItem
+	if err := s.Conn.Call(ctx, "acme-lsp/resolveCompletionItemOnDocument", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (s *serverDispatcher) Commands(ctx context.Context) ([]ServerCommands, error) {
+	var result []ServerCommands
+	if err := s.Conn.Call(ctx, "acme-lsp/commands", nil, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 func (s *serverDispatcher) SyncDocument(ctx context.Context, params *SyncDocumentParams) error {
 	return s.Conn.Notify(ctx, "acme-lsp/syncDocument", params)
 }
 
+func (s *serverDispatcher) WorkspaceSymbols(ctx context.Context, params *WorkspaceSymbolsParams) ([]protocol.SymbolInformation, error) {
+	var result []protocol.SymbolInformation
+	if err := s.Conn.Call(ctx, "acme-lsp/workspaceSymbols", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *serverDispatcher) Diagnostics(ctx context.Context, params *DiagnosticsParams) ([]protocol.PublishDiagnosticsParams, error) {
+	var result []protocol.PublishDiagnosticsParams
+	if err := s.Conn.Call(ctx, "acme-lsp/diagnostics", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 var _ protocol.Server = (*NotImplementedServer)(nil)
 
 // NotImplementedServer is a stub implementation of protocol.Server.
@@ -0,0 +1,240 @@
+package text
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// lineChange replaces old lines [start, end) with lines.
+type lineChange struct {
+	start, end int
+	lines      []string
+}
+
+// Diff returns the unified diff of the changes the edits would make to
+// the text old of file filename. It returns an empty string if the
+// edits don't change anything.
+func Diff(filename, old string, edits []protocol.TextEdit) string {
+	edits = append(EditList(nil), edits...)
+	sort.Sort(EditList(edits))
+	lines := splitLines(old)
+
+	var changes []lineChange
+	for i := 0; i < len(edits); {
+		// Edits sharing a line are combined into one change.
+		start := int(edits[i].Range.Start.Line)
+		end := editEndLine(&edits[i])
+		j := i + 1
+		for ; j < len(edits) && int(edits[j].Range.Start.Line) < end; j++ {
+			if e := editEndLine(&edits[j]); e > end {
+				end = e
+			}
+		}
+		if start > len(lines) {
+			start = len(lines)
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		region := []rune(strings.Join(lines[start:end], ""))
+		off, _ := GetNewlineOffsets(strings.NewReader(string(region)))
+		var sb strings.Builder
+		q := 0
+		for _, e := range edits[i:j] {
+			q0 := off.LineToOffset(int(e.Range.Start.Line)-start, int(e.Range.Start.Character))
+			q1 := off.LineToOffset(int(e.Range.End.Line)-start, int(e.Range.End.Character))
+			if q0 < q {
+				q0 = q
+			}
+			if q1 < q0 {
+				q1 = q0
+			}
+			sb.WriteString(string(region[q:q0]))
+			sb.WriteString(e.NewText)
+			q = q1
+		}
+		sb.WriteString(string(region[q:]))
+		newLines := splitLines(sb.String())
+
+		// Don't show lines left unchanged (e.g. after an insertion).
+		for start < end && len(newLines) > 0 && lines[start] == newLines[0] {
+			start++
+			newLines = newLines[1:]
+		}
+		for start < end && len(newLines) > 0 && lines[end-1] == newLines[len(newLines)-1] {
+			end--
+			newLines = newLines[:len(newLines)-1]
+		}
+		if start < end || len(newLines) > 0 {
+			changes = append(changes, lineChange{start: start, end: end, lines: newLines})
+		}
+		i = j
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %v\n+++ %v\n", filename, filename)
+	delta := 0 // number of lines added minus removed before current hunk
+	for i := 0; i < len(changes); {
+		// Changes close to each other are shown in the same hunk.
+		j := i + 1
+		for ; j < len(changes) && changes[j].start-changes[j-1].end <= 2*diffContext; j++ {
+		}
+		first := max(changes[i].start-diffContext, 0)
+		last := min(changes[j-1].end+diffContext, len(lines))
+
+		var hunk strings.Builder
+		oldCount, newCount := 0, 0
+		p := first
+		for _, c := range changes[i:j] {
+			for ; p < c.start; p++ {
+				writeDiffLine(&hunk, ' ', lines[p])
+				oldCount++
+				newCount++
+			}
+			for ; p < c.end; p++ {
+				writeDiffLine(&hunk, '-', lines[p])
+				oldCount++
+			}
+			for _, l := range c.lines {
+				writeDiffLine(&hunk, '+', l)
+				newCount++
+			}
+		}
+		for ; p < last; p++ {
+			writeDiffLine(&hunk, ' ', lines[p])
+			oldCount++
+			newCount++
+		}
+		fmt.Fprintf(&sb, "@@ -%v +%v @@\n", hunkRange(first, oldCount), hunkRange(first+delta, newCount))
+		sb.WriteString(hunk.String())
+		for _, c := range changes[i:j] {
+			delta += len(c.lines) - (c.end - c.start)
+		}
+		i = j
+	}
+	return sb.String()
+}
+
+// maxLineEditsCost bounds the size of the table used by LineEdits to
+// find the lines common to both texts.
+const maxLineEditsCost = 1 << 22
+
+// LineEdits returns edits of whole lines turning text old into text new,
+// so that changes not made by edits (e.g. made by several rounds of
+// edits) can be shown with Diff.
+func LineEdits(old, new string) []protocol.TextEdit {
+	a, b := splitLines(old), splitLines(new)
+
+	// Lines common to the start and end of both texts are left as is.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a, b = a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	var edits []protocol.TextEdit
+	replace := func(i0, i1 int, lines []string) {
+		edits = append(edits, protocol.TextEdit{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: uint32(pre + i0)},
+				End:   protocol.Position{Line: uint32(pre + i1)},
+			},
+			NewText: strings.Join(lines, ""),
+		})
+	}
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if (len(a)+1)*(len(b)+1) > maxLineEditsCost {
+		replace(0, len(a), b)
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence
+	// of lines a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	i0, j0 := 0, 0 // start of the current change
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			if i0 < i || j0 < j {
+				replace(i0, i, b[j0:j])
+			}
+			i++
+			j++
+			i0, j0 = i, j
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			i++
+		default:
+			j++
+		}
+	}
+	if i0 < i || j0 < j {
+		replace(i0, i, b[j0:j])
+	}
+	return edits
+}
+
+// editEndLine returns the line following the last line modified by e.
+func editEndLine(e *protocol.TextEdit) int {
+	r := e.Range
+	if r.End.Character == 0 && r.End.Line > r.Start.Line {
+		return int(r.End.Line)
+	}
+	return int(r.End.Line) + 1
+}
+
+// splitLines splits s into lines, each keeping its terminating newline.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func writeDiffLine(sb *strings.Builder, op byte, line string) {
+	sb.WriteByte(op)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n\\ No newline at end of file\n")
+	}
+}
+
+// hunkRange formats the line range of a hunk starting at zero-based
+// line start and spanning n lines.
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, n)
+}
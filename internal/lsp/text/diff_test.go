@@ -0,0 +1,102 @@
+package text
+
+import (
+	"testing"
+
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+func TestDiff(t *testing.T) {
+	edit := func(l0, c0, l1, c1 uint32, text string) protocol.TextEdit {
+		return protocol.TextEdit{
+			Range: protocol.Range{
+				Start: protocol.Position{Line: l0, Character: c0},
+				End:   protocol.Position{Line: l1, Character: c1},
+			},
+			NewText: text,
+		}
+	}
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	for _, tc := range []struct {
+		name  string
+		old   string
+		edits []protocol.TextEdit
+		want  string
+	}{
+		{"NoEdits", old, nil, ""},
+		{"NoChange", old, []protocol.TextEdit{edit(1, 0, 1, 1, "b")}, ""},
+		{
+			"Replace",
+			old,
+			[]protocol.TextEdit{edit(4, 0, 4, 1, "E")},
+			"--- f.go\n+++ f.go\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			"SameLine",
+			"x := y + y\n",
+			[]protocol.TextEdit{edit(0, 9, 0, 10, "z"), edit(0, 5, 0, 6, "z")},
+			"--- f.go\n+++ f.go\n@@ -1 +1 @@\n-x := y + y\n+x := z + z\n",
+		},
+		{
+			"TwoHunks",
+			old,
+			[]protocol.TextEdit{edit(0, 0, 1, 0, ""), edit(12, 0, 12, 0, "new\n")},
+			"--- f.go\n+++ f.go\n@@ -1,4 +1,3 @@\n-a\n b\n c\n d\n@@ -10,5 +9,6 @@\n j\n k\n l\n+new\n m\n n\n",
+		},
+		{
+			"NoNewlineAtEOF",
+			"a\nb",
+			[]protocol.TextEdit{edit(1, 0, 1, 1, "c")},
+			"--- f.go\n+++ f.go\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Diff("f.go", tc.old, tc.edits)
+			if got != tc.want {
+				t.Errorf("Diff returned\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestLineEdits(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	for _, tc := range []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"NoChange", old, old, ""},
+		{
+			"Replace",
+			old,
+			"a\nb\nc\nd\nE\nf\ng\nh\ni\nj\nk\nl\nm\nn\n",
+			"--- f.go\n+++ f.go\n@@ -2,7 +2,7 @@\n b\n c\n d\n-e\n+E\n f\n g\n h\n",
+		},
+		{
+			"TwoHunks",
+			old,
+			"b\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nnew\nm\nn\n",
+			"--- f.go\n+++ f.go\n@@ -1,4 +1,3 @@\n-a\n b\n c\n d\n@@ -10,5 +9,6 @@\n j\n k\n l\n+new\n m\n n\n",
+		},
+		{
+			"NoNewlineAtEOF",
+			"a\nb",
+			"a\nc",
+			"--- f.go\n+++ f.go\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			"Empty",
+			"",
+			"a\n",
+			"--- f.go\n+++ f.go\n@@ -0,0 +1 @@\n+a\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Diff("f.go", tc.old, LineEdits(tc.old, tc.new))
+			if got != tc.want {
+				t.Errorf("Diff of LineEdits returned\n%v\nwant\n%v", got, tc.want)
+			}
+		})
+	}
+}
@@ -84,6 +84,9 @@ type AddressableFile interface {
 
 	// CurrentAddr returns the address of current selection.
 	CurrentAddr() (q0, q1 int, err error)
+
+	// SetCurrentAddr selects the text in rune range [q0, q1).
+	SetCurrentAddr(q0, q1 int) error
 }
 
 // DocumentURI returns the URI and filename of a file being edited.
@@ -129,6 +132,41 @@ func Position(f AddressableFile) (pos *protocol.TextDocumentPositionParams, file
 	return PositionQ0(f, q0)
 }
 
+// Selection returns the location of the current selection within a file being edited.
+func Selection(f AddressableFile) (loc *protocol.Location, filename string, err error) {
+	q0, q1, err := f.CurrentAddr()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get current address: %v", err)
+	}
+	name, err := f.Filename()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get window filename: %v", err)
+	}
+	reader, err := f.Reader()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not get window body reader: %v", err)
+	}
+	off, err := GetNewlineOffsets(reader)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get newline offset: %v", err)
+	}
+	line0, col0 := off.OffsetToLine(q0)
+	line1, col1 := off.OffsetToLine(q1)
+	return &protocol.Location{
+		URI: ToURI(name),
+		Range: protocol.Range{
+			Start: protocol.Position{
+				Line:      uint32(line0),
+				Character: uint32(col0),
+			},
+			End: protocol.Position{
+				Line:      uint32(line1),
+				Character: uint32(col1),
+			},
+		},
+	}, name, nil
+}
+
 // ToURI converts filename to URI.
 func ToURI(filename string) protocol.DocumentURI {
 	u := &url.URL{
@@ -160,8 +198,20 @@ func CutPrefix(s, prefix string) (after string, found bool) {
 	return s[len(prefix):], true
 }
 
+// Menu gives access to the files open in the text editor.
 type Menu interface {
 	Open(filename string) (AddressableFile, error)
+
+	// Create opens the file filename, which was just created on disk.
+	Create(filename string) error
+
+	// Rename retargets the open files named oldname, or within
+	// directory oldname, to newname after it was renamed on disk.
+	Rename(oldname, newname string) error
+
+	// Close closes the open files named filename, or within directory
+	// filename, after it was deleted on disk.
+	Close(filename string) error
 }
 
 type AcmeMenu struct{}
@@ -178,3 +228,86 @@ func (m *AcmeMenu) Open(filename string) (AddressableFile, error) {
 	}
 	return nil, fmt.Errorf("%v: not open in acme", filename)
 }
+
+func (m *AcmeMenu) Create(filename string) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		if info.Name == filename {
+			// Reload the window in case the file was overwritten.
+			w, err := acmeutil.OpenWin(info.ID)
+			if err != nil {
+				return err
+			}
+			defer w.CloseFiles()
+			return w.Ctl("get")
+		}
+	}
+	w, err := acmeutil.NewWin()
+	if err != nil {
+		return err
+	}
+	defer w.CloseFiles()
+	if err := w.Name("%v", filename); err != nil {
+		return err
+	}
+	return w.Ctl("get")
+}
+
+func (m *AcmeMenu) Rename(oldname, newname string) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		rest, ok := withinPath(info.Name, oldname)
+		if !ok {
+			continue
+		}
+		w, err := acmeutil.OpenWin(info.ID)
+		if err != nil {
+			return err
+		}
+		err = w.Name("%v%v", newname, rest)
+		w.CloseFiles()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *AcmeMenu) Close(filename string) error {
+	wins, err := acme.Windows()
+	if err != nil {
+		return fmt.Errorf("failed to read list of acme index: %v", err)
+	}
+	for _, info := range wins {
+		if _, ok := withinPath(info.Name, filename); !ok {
+			continue
+		}
+		w, err := acmeutil.OpenWin(info.ID)
+		if err != nil {
+			return err
+		}
+		// Don't discard unsaved changes.
+		err = w.Del(false)
+		w.CloseFiles()
+		if err != nil {
+			return fmt.Errorf("cannot close window %v: %v", info.Name, err)
+		}
+	}
+	return nil
+}
+
+// withinPath reports whether name is dir or a path within directory dir,
+// and returns the rest of name following dir.
+func withinPath(name, dir string) (rest string, ok bool) {
+	rest, ok = CutPrefix(name, dir)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return "", false
+	}
+	return rest, true
+}
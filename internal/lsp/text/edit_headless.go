@@ -82,6 +82,12 @@ func (f *HeadlessFile) CurrentAddr() (q0, q1 int, err error) {
 	return f.q0, f.q1, nil
 }
 
+// SetCurrentAddr sets the address of current selection.
+func (f *HeadlessFile) SetCurrentAddr(q0, q1 int) error {
+	f.q0, f.q1 = q0, q1
+	return nil
+}
+
 // CloseFiles closes all the open files associated with the file.
 func (f *HeadlessFile) CloseFiles() {
 	f.file.Close()
@@ -92,3 +98,12 @@ type HeadlessMenu struct{}
 func (m *HeadlessMenu) Open(filename string) (AddressableFile, error) {
 	return NewHeadlessFile(filename, 0, 0)
 }
+
+// Create does nothing because files are edited directly on disk.
+func (m *HeadlessMenu) Create(filename string) error { return nil }
+
+// Rename does nothing because files are edited directly on disk.
+func (m *HeadlessMenu) Rename(oldname, newname string) error { return nil }
+
+// Close does nothing because files are edited directly on disk.
+func (m *HeadlessMenu) Close(filename string) error { return nil }
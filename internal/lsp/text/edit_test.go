@@ -47,3 +47,22 @@ func TestToPath(t *testing.T) {
 		}
 	}
 }
+
+func TestWithinPath(t *testing.T) {
+	for _, tc := range []struct {
+		name, dir string
+		rest      string
+		ok        bool
+	}{
+		{"/src/a.rs", "/src/a.rs", "", true},
+		{"/src/a/b.rs", "/src/a", "/b.rs", true},
+		{"/src/a/", "/src/a", "/", true},
+		{"/src/ab.rs", "/src/a", "", false},
+		{"/src/b.rs", "/src/a", "", false},
+	} {
+		rest, ok := withinPath(tc.name, tc.dir)
+		if rest != tc.rest || ok != tc.ok {
+			t.Errorf("withinPath(%q, %q) is (%q, %v); expected (%q, %v)", tc.name, tc.dir, rest, ok, tc.rest, tc.ok)
+		}
+	}
+}
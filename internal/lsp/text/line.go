@@ -68,6 +68,24 @@ func (off *nlOffsets) OffsetToLine(offset int) (line, col int) {
 	panic("unreachable")
 }
 
+// RuneColumn returns the rune offset within line of LSP character
+// offset col, which counts UTF-16 code units. The result is at most
+// the length of line.
+func RuneColumn(line []rune, col int) int {
+	n := 0
+	for i, r := range line {
+		if n >= col {
+			return i
+		}
+		if l := utf16.RuneLen(r); l > 0 {
+			n += l
+		} else {
+			n++ // invalid rune, encoded as U+FFFD
+		}
+	}
+	return len(line)
+}
+
 // GetLastPosition returns the LSP position for the end of give string.
 func GetLastPosition(s string) (line, col int) {
 	for _, r := range s {
@@ -65,6 +65,27 @@ func TestLineOffsets(t *testing.T) {
 	}
 }
 
+func TestRuneColumn(t *testing.T) {
+	for _, tc := range []struct {
+		line     string
+		col, off int
+	}{
+		{"", 0, 0},
+		{"", 3, 0},
+		{"abc", 0, 0},
+		{"abc", 2, 2},
+		{"abc", 5, 3},
+		{"αβc", 2, 2},
+		{"a😀b", 1, 1},
+		{"a😀b", 3, 2},
+		{"a😀b", 4, 3},
+	} {
+		if off := RuneColumn([]rune(tc.line), tc.col); off != tc.off {
+			t.Errorf("RuneColumn(%q, %v) = %v; expected %v", tc.line, tc.col, off, tc.off)
+		}
+	}
+}
+
 func TestLineOffsetsLeftover(t *testing.T) {
 	var testCases = []struct {
 		file              string
@@ -0,0 +1,414 @@
+package text
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+// Documentation renders the documentation v returned by a server as
+// plain text suitable for an acme window. V can be a plain text string,
+// a MarkupContent of either kind, a MarkedString, a MarkedStringWithLanguage
+// or a slice of MarkedStrings. Markdown is converted to plain text and the
+// targets of links are listed as numbered footnotes at the end, so that
+// they can be plumbed. The result is empty or ends with a newline.
+func Documentation(v interface{}) string {
+	var r markdownRenderer
+	r.render(v)
+	return r.String()
+}
+
+// Markdown converts the markdown text s to plain text, the way
+// Documentation does.
+func Markdown(s string) string {
+	var r markdownRenderer
+	r.markdown(s)
+	return r.String()
+}
+
+type markdownRenderer struct {
+	sb    strings.Builder
+	blank bool     // next line is separated from the previous ones by a blank line
+	links []string // link targets, in the order of their footnotes
+}
+
+func (r *markdownRenderer) String() string {
+	if len(r.links) == 0 {
+		return r.sb.String()
+	}
+	r.blank = true
+	for i, l := range r.links {
+		r.line(fmt.Sprintf("[%v] %v", i+1, l))
+	}
+	r.links = nil
+	return r.sb.String()
+}
+
+func (r *markdownRenderer) render(v interface{}) {
+	switch v := v.(type) {
+	case string:
+		r.plainText(v)
+	case protocol.MarkupContent:
+		r.markupContent(&v)
+	case *protocol.MarkupContent:
+		if v != nil {
+			r.markupContent(v)
+		}
+	case protocol.MarkedString:
+		if s, ok := v.Value.(string); ok {
+			// A MarkedString string is markdown, unlike the
+			// plain strings used for documentation.
+			r.markdown(s)
+			return
+		}
+		r.render(v.Value)
+	case protocol.MarkedStringWithLanguage:
+		r.code(v.Value)
+	case []protocol.MarkedString:
+		for _, ms := range v {
+			r.blank = true
+			r.render(ms)
+		}
+	}
+}
+
+func (r *markdownRenderer) markupContent(mc *protocol.MarkupContent) {
+	if mc.Kind == protocol.Markdown {
+		r.markdown(mc.Value)
+		return
+	}
+	r.plainText(mc.Value)
+}
+
+// line writes line s, preceded by a blank line if needed.
+func (r *markdownRenderer) line(s string) {
+	if r.blank && r.sb.Len() > 0 {
+		r.sb.WriteString("\n")
+	}
+	r.blank = false
+	r.sb.WriteString(s)
+	r.sb.WriteString("\n")
+}
+
+func (r *markdownRenderer) plainText(s string) {
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimRight(l, " \t\r")
+		if l == "" {
+			r.blank = true
+			continue
+		}
+		r.line(l)
+	}
+}
+
+func (r *markdownRenderer) code(s string) {
+	r.blank = true
+	for _, l := range strings.Split(strings.TrimRight(s, "\n"), "\n") {
+		r.line(strings.TrimRight(l, "\r"))
+	}
+	r.blank = true
+}
+
+func (r *markdownRenderer) markdown(s string) {
+	lines := strings.Split(s, "\n")
+	para := false // previous line is part of a paragraph
+	for i := 0; i < len(lines); i++ {
+		l := strings.TrimRight(lines[i], "\r")
+		indent, rest := splitIndent(l)
+		switch {
+		case strings.TrimSpace(l) == "":
+			r.blank = true
+			para = false
+
+		case indent < 4 && isFence(rest):
+			// Fenced code block, shown verbatim without the fences.
+			fence := rest[:len(rest)-len(strings.TrimLeft(rest, rest[:1]))]
+			r.blank = true
+			for i++; i < len(lines); i++ {
+				cl := strings.TrimRight(lines[i], "\r")
+				if ci, crest := splitIndent(cl); ci < 4 && strings.HasPrefix(crest, fence) && strings.Trim(crest, fence[:1]+" \t") == "" {
+					break
+				}
+				r.line(trimIndent(cl, indent))
+			}
+			r.blank = true
+			para = false
+
+		case indent >= 4 && !para:
+			// Indented code block.
+			r.line(l)
+
+		case indent < 4 && para && isSetextUnderline(rest):
+			// The previous line was a heading.
+			r.blank = true
+			para = false
+
+		case indent < 4 && isThematicBreak(rest):
+			r.blank = true
+			para = false
+
+		case indent < 4 && isHeading(rest):
+			h := strings.TrimLeft(rest, "#")
+			h = strings.TrimSpace(h)
+			if t := strings.TrimRight(h, "#"); t == "" || strings.HasSuffix(t, " ") {
+				h = strings.TrimSpace(t)
+			}
+			r.blank = true
+			r.line(r.inline(h))
+			r.blank = true
+			para = false
+
+		default:
+			// Trailing spaces or backslash mark hard line breaks,
+			// which are kept like soft ones.
+			l = strings.TrimRight(l, " \t")
+			if strings.HasSuffix(l, "\\") && !strings.HasSuffix(l, "\\\\") {
+				l = l[:len(l)-1]
+			}
+			r.line(r.inline(l))
+			para = true
+		}
+	}
+}
+
+// splitIndent returns the width of the indentation of line l and the
+// rest of the line.
+func splitIndent(l string) (int, string) {
+	n := 0
+	for i, c := range l {
+		switch c {
+		case ' ':
+			n++
+		case '\t':
+			n += 4 - n%4
+		default:
+			return n, l[i:]
+		}
+	}
+	return n, ""
+}
+
+// trimIndent removes up to n spaces of indentation from l.
+func trimIndent(l string, n int) string {
+	for i := 0; i < n && strings.HasPrefix(l, " "); i++ {
+		l = l[1:]
+	}
+	return l
+}
+
+func isFence(s string) bool {
+	return strings.HasPrefix(s, "```") || strings.HasPrefix(s, "~~~")
+}
+
+func isSetextUnderline(s string) bool {
+	s = strings.TrimRight(s, " \t")
+	return s != "" && (strings.Trim(s, "=") == "" || strings.Trim(s, "-") == "")
+}
+
+func isThematicBreak(s string) bool {
+	s = strings.NewReplacer(" ", "", "\t", "").Replace(s)
+	if len(s) < 3 {
+		return false
+	}
+	switch s[0] {
+	case '-', '*', '_':
+		return strings.Trim(s, s[:1]) == ""
+	}
+	return false
+}
+
+func isHeading(s string) bool {
+	n := len(s) - len(strings.TrimLeft(s, "#"))
+	return n >= 1 && n <= 6 && (n == len(s) || s[n] == ' ' || s[n] == '\t')
+}
+
+// inline converts the inline markup of s (code spans, emphasis, links,
+// escapes) to plain text, adding link targets to the footnotes.
+func (r *markdownRenderer) inline(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]):
+			sb.WriteByte(s[i+1])
+			i += 2
+			continue
+
+		case c == '`':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			if end := closingBackticks(s, i+n, n); end >= 0 {
+				code := s[i+n : end]
+				if len(code) > 1 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.Trim(code, " ") != "" {
+					code = code[1 : len(code)-1]
+				}
+				sb.WriteString(code)
+				i = end + n
+				continue
+			}
+			sb.WriteString(s[i : i+n])
+			i += n
+			continue
+
+		case c == '[' || (c == '!' && strings.HasPrefix(s[i:], "![")):
+			start := i + 1
+			if c == '!' {
+				start++
+			}
+			if text, url, end, ok := parseLink(s, start); ok {
+				text = r.inline(text)
+				sb.WriteString(text)
+				if url != "" && url != text {
+					fmt.Fprintf(&sb, "[%v]", r.footnote(url))
+				}
+				i = end
+				continue
+			}
+
+		case c == '<':
+			if end := strings.IndexByte(s[i:], '>'); end > 0 {
+				url := s[i+1 : i+end]
+				if isAutolink(url) {
+					sb.WriteString(url)
+					i += end + 1
+					continue
+				}
+			}
+
+		case c == '*' || c == '_':
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], s[i:i+1]))
+			if end := closingEmphasis(s, i, n); end >= 0 {
+				sb.WriteString(r.inline(s[i+n : end]))
+				i = end + n
+				continue
+			}
+			sb.WriteString(s[i : i+n])
+			i += n
+			continue
+		}
+		sb.WriteByte(c)
+		i++
+	}
+	return sb.String()
+}
+
+// footnote returns the number of the footnote for link target url.
+func (r *markdownRenderer) footnote(url string) int {
+	for i, l := range r.links {
+		if l == url {
+			return i + 1
+		}
+	}
+	r.links = append(r.links, url)
+	return len(r.links)
+}
+
+// closingBackticks returns the index of the run of exactly n backticks
+// closing a code span opened before index i, or -1.
+func closingBackticks(s string, i, n int) int {
+	for i < len(s) {
+		j := strings.IndexByte(s[i:], '`')
+		if j < 0 {
+			return -1
+		}
+		j += i
+		m := len(s[j:]) - len(strings.TrimLeft(s[j:], "`"))
+		if m == n {
+			return j
+		}
+		i = j + m
+	}
+	return -1
+}
+
+// closingEmphasis returns the index of the delimiter run closing the
+// emphasis opened by the run of n delimiters at index i, or -1.
+func closingEmphasis(s string, i, n int) int {
+	d := s[i]
+	before, _ := utf8.DecodeLastRuneInString(s[:i])
+	after, _ := utf8.DecodeRuneInString(s[i+n:])
+	if i+n == len(s) || unicode.IsSpace(after) {
+		return -1
+	}
+	if d == '_' && i > 0 && isWordRune(before) {
+		return -1
+	}
+	for j := i + n; j < len(s); j++ {
+		if s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] != d {
+			continue
+		}
+		m := len(s[j:]) - len(strings.TrimLeft(s[j:], s[j:j+1]))
+		prev, _ := utf8.DecodeLastRuneInString(s[:j])
+		next, _ := utf8.DecodeRuneInString(s[j+m:])
+		if m == n && !unicode.IsSpace(prev) && (d == '*' || j+m == len(s) || !isWordRune(next)) {
+			return j
+		}
+		j += m - 1
+	}
+	return -1
+}
+
+// parseLink parses the link whose text starts at index i, just after
+// the opening bracket. It returns the link text and target, and the
+// index following the link.
+func parseLink(s string, i int) (text, url string, end int, ok bool) {
+	depth := 1
+	j := i
+	for ; j < len(s) && depth > 0; j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+	}
+	if depth > 0 || j >= len(s) || s[j] != '(' {
+		return "", "", 0, false
+	}
+	text = s[i : j-1]
+	k := strings.IndexByte(s[j:], ')')
+	if k < 0 {
+		return "", "", 0, false
+	}
+	dest := strings.TrimSpace(s[j+1 : j+k])
+	if strings.HasPrefix(dest, "<") {
+		if e := strings.IndexByte(dest, '>'); e > 0 {
+			dest = dest[1:e]
+		}
+	} else if f := strings.Fields(dest); len(f) > 0 {
+		dest = f[0] // drop the title
+	}
+	return text, dest, j + k + 1, true
+}
+
+func isAutolink(s string) bool {
+	if strings.ContainsAny(s, " \t<") {
+		return false
+	}
+	scheme, rest, ok := strings.Cut(s, ":")
+	if !ok || len(scheme) < 2 || rest == "" {
+		return false
+	}
+	for _, c := range scheme {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '+' || c == '.' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func isASCIIPunct(c byte) bool {
+	return c < utf8.RuneSelf && unicode.IsPunct(rune(c)) || strings.IndexByte("$+<=>^`|~", c) >= 0
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
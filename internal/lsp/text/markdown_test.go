@@ -0,0 +1,84 @@
+package text
+
+import (
+	"testing"
+
+	"9fans.net/internal/go-lsp/lsp/protocol"
+)
+
+func TestMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"Empty", "", ""},
+		{"Paragraphs", "one\ntwo\n\n\n\nthree  \n", "one\ntwo\n\nthree\n"},
+		{
+			"GoplsHover",
+			"```go\nfunc fmt.Println(a ...any) (n int, err error)\n```\n\n---\n\nPrintln formats using the default formats.\n\n\n---\n\n[`fmt.Println` on pkg.go.dev](https://pkg.go.dev/fmt#Println)",
+			"func fmt.Println(a ...any) (n int, err error)\n\nPrintln formats using the default formats.\n\nfmt.Println on pkg.go.dev[1]\n\n[1] https://pkg.go.dev/fmt#Println\n",
+		},
+		{"CodeSpan", "use `a*b*c` or `` `x` ``", "use a*b*c or `x`\n"},
+		{"UnclosedCodeSpan", "a `b", "a `b\n"},
+		{"Emphasis", "*a* **b** _c_ __d__ ***e***", "a b c d e\n"},
+		{"NoEmphasis", "snake_case_name a * b 2 * 3 **", "snake_case_name a * b 2 * 3 **\n"},
+		{"Escapes", `\*not emphasis\* \[x\] a\b`, "*not emphasis* [x] a\\b\n"},
+		{"HardBreak", "a\\\nb", "a\nb\n"},
+		{
+			"Links",
+			"[a](http://x.org/a \"title\") [b](<http://x.org/b>) [a again](http://x.org/a) ![img](http://x.org/i.png) [http://y.org](http://y.org) [not a link]",
+			"a[1] b[2] a again[1] img[3] http://y.org [not a link]\n\n[1] http://x.org/a\n[2] http://x.org/b\n[3] http://x.org/i.png\n",
+		},
+		{"Autolink", "see <https://go.dev/> and <b>", "see https://go.dev/ and <b>\n"},
+		{"Headings", "# Title #\ntext\n## C#\nSub\n===\nmore", "Title\n\ntext\n\nC#\n\nSub\n\nmore\n"},
+		{"ThematicBreaks", "a\n\n* * *\n\n___\nb", "a\n\nb\n"},
+		{
+			"CodeBlocks",
+			"text:\n\n    indented *code*\n\n~~~~\n```\n[x](y)\n~~~~\nafter",
+			"text:\n\n    indented *code*\n\n```\n[x](y)\n\nafter\n",
+		},
+		{"UnclosedFence", "```\ncode", "code\n"},
+		{"List", "- `a`: first\n- *b*: second", "- a: first\n- b: second\n"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Markdown(tc.in); got != tc.want {
+				t.Errorf("Markdown(%q) is %q; want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDocumentation(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"Nil", nil, ""},
+		{"String", "*not* markdown\n\n", "*not* markdown\n"},
+		{"PlainText", protocol.MarkupContent{Kind: protocol.PlainText, Value: "[x](y)"}, "[x](y)\n"},
+		{"Markdown", &protocol.MarkupContent{Kind: protocol.Markdown, Value: "[x](y)"}, "x[1]\n\n[1] y\n"},
+		{"MarkedString", protocol.MarkedString{Value: "**x**"}, "x\n"},
+		{
+			"MarkedStringWithLanguage",
+			protocol.MarkedString{Value: protocol.MarkedStringWithLanguage{Language: "go", Value: "var *x* int\n"}},
+			"var *x* int\n",
+		},
+		{
+			"MarkedStrings",
+			[]protocol.MarkedString{
+				{Value: protocol.MarkedStringWithLanguage{Language: "go", Value: "func f()"}},
+				{Value: "f does [this](http://a) and [that](http://b)."},
+				{Value: "See [this](http://a)."},
+			},
+			"func f()\n\nf does this[1] and that[2].\n\nSee this[1].\n\n[1] http://a\n[2] http://b\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Documentation(tc.in); got != tc.want {
+				t.Errorf("Documentation(%#v) is %q; want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
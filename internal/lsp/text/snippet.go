@@ -0,0 +1,256 @@
+package text
+
+import (
+	"strconv"
+	"unicode"
+)
+
+// ExpandSnippet expands a snippet in the LSP snippet syntax (e.g.
+// "fmt.Println(${1:a ...any})$0") into plain text. It returns the text
+// and the range [q0, q1) of runes within it to select: the first
+// placeholder, or the final tabstop $0 if there are no others, or the
+// end of the text if there are no tabstops at all.
+//
+// Placeholders are replaced by their default text, and choices by their
+// first option. Mirrors, which are tabstops without text repeating a
+// placeholder or choice, are replaced by its text, whether they come
+// before or after it. Variables are replaced by their default text, if
+// any, since we don't know their values.
+func ExpandSnippet(snippet string) (text string, q0, q1 int) {
+	p := &snippetParser{
+		src:   []rune(snippet),
+		stops: make(map[int][2]int),
+		texts: make(map[int][]rune),
+	}
+	p.parse(false)
+
+	// A mirror coming before its placeholder expanded to nothing. Now
+	// that the text of every placeholder is known, expand again.
+	p = &snippetParser{
+		src:   p.src,
+		stops: make(map[int][2]int),
+		texts: p.texts,
+	}
+	p.parse(false)
+
+	first := -1
+	for n := range p.stops {
+		if n > 0 && (first < 0 || n < first) {
+			first = n
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+	if r, ok := p.stops[first]; ok {
+		return string(p.out), r[0], r[1]
+	}
+	return string(p.out), len(p.out), len(p.out)
+}
+
+type snippetParser struct {
+	src   []rune
+	i     int // position in src
+	out   []rune
+	stops map[int][2]int // range in out of the first occurrence of each tabstop
+	texts map[int][]rune // text of the first placeholder or choice of each tabstop
+}
+
+// parse expands text until the end of the source or, if inside is true,
+// until the '}' closing the enclosing placeholder.
+func (p *snippetParser) parse(inside bool) {
+	for p.i < len(p.src) {
+		c := p.src[p.i]
+		switch {
+		case c == '\\' && p.i+1 < len(p.src) && isSnippetEscape(p.src[p.i+1]):
+			p.out = append(p.out, p.src[p.i+1])
+			p.i += 2
+		case c == '}' && inside:
+			return
+		case c == '$' && p.dollar():
+		default:
+			p.out = append(p.out, c)
+			p.i++
+		}
+	}
+}
+
+// dollar expands the tabstop, placeholder, choice or variable starting
+// at the '$' at the current position. It reports false, without
+// consuming anything, if there isn't one.
+func (p *snippetParser) dollar() bool {
+	start := p.i
+	p.i++
+	if n, ok := p.int(); ok {
+		q := len(p.out)
+		p.mirror(n)
+		p.addStop(n, q, len(p.out))
+		return true
+	}
+	if p.name() != "" {
+		return true // variable without default
+	}
+	if !p.next('{') {
+		p.i = start
+		return false
+	}
+	if n, ok := p.int(); ok {
+		q := len(p.out)
+		switch {
+		case p.next('}'):
+			p.mirror(n)
+		case p.next(':'):
+			p.parse(true)
+			p.next('}') // if unterminated, keep what we have
+			p.addText(n, q, len(p.out))
+		case p.next('|'):
+			p.choice()
+			p.addText(n, q, len(p.out))
+		case p.peek('/'):
+			p.transform()
+		default:
+			p.i = start
+			return false
+		}
+		p.addStop(n, q, len(p.out))
+		return true
+	}
+	if p.name() != "" {
+		switch {
+		case p.next('}'):
+		case p.next(':'):
+			p.parse(true)
+			p.next('}')
+		case p.peek('/'):
+			p.transform()
+		default:
+			p.i = start
+			return false
+		}
+		return true
+	}
+	p.i = start
+	return false
+}
+
+// choice expands the options of a choice, following "${n|", into the
+// first one.
+func (p *snippetParser) choice() {
+	first := true
+	for p.i < len(p.src) {
+		c := p.src[p.i]
+		switch {
+		case c == '\\' && p.i+1 < len(p.src) && (isSnippetEscape(p.src[p.i+1]) || p.src[p.i+1] == ',' || p.src[p.i+1] == '|'):
+			if first {
+				p.out = append(p.out, p.src[p.i+1])
+			}
+			p.i += 2
+		case c == '|':
+			p.i++
+			p.next('}')
+			return
+		case c == ',':
+			first = false
+			p.i++
+		default:
+			if first {
+				p.out = append(p.out, c)
+			}
+			p.i++
+		}
+	}
+}
+
+// transform skips a transform ("/regex/format/options}"), including the
+// closing '}'. Transforms can't be applied without knowing the value
+// being transformed, so they expand to nothing.
+func (p *snippetParser) transform() {
+	p.i++                                  // '/'
+	p.skipTo('/')                          // regex
+	for p.i < len(p.src) && !p.next('/') { // format
+		switch {
+		case p.src[p.i] == '\\':
+			p.i += 2
+		case p.next('$') && p.next('{'):
+			p.skipTo('}')
+		default:
+			p.i++
+		}
+	}
+	p.skipTo('}') // options
+}
+
+// skipTo skips past the next unescaped c.
+func (p *snippetParser) skipTo(c rune) {
+	for p.i < len(p.src) {
+		switch p.src[p.i] {
+		case '\\':
+			p.i += 2
+		case c:
+			p.i++
+			return
+		default:
+			p.i++
+		}
+	}
+}
+
+// mirror repeats the text of the first placeholder or choice of tabstop
+// n, if any.
+func (p *snippetParser) mirror(n int) {
+	p.out = append(p.out, p.texts[n]...)
+}
+
+func (p *snippetParser) addText(n, q0, q1 int) {
+	if _, ok := p.texts[n]; !ok {
+		p.texts[n] = append([]rune(nil), p.out[q0:q1]...)
+	}
+}
+
+func (p *snippetParser) addStop(n, q0, q1 int) {
+	if _, ok := p.stops[n]; !ok {
+		p.stops[n] = [2]int{q0, q1}
+	}
+}
+
+func (p *snippetParser) int() (int, bool) {
+	j := p.i
+	for j < len(p.src) && '0' <= p.src[j] && p.src[j] <= '9' {
+		j++
+	}
+	if j == p.i {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(p.src[p.i:j]))
+	if err != nil {
+		return 0, false
+	}
+	p.i = j
+	return n, true
+}
+
+func (p *snippetParser) name() string {
+	j := p.i
+	for j < len(p.src) && (p.src[j] == '_' || unicode.IsLetter(p.src[j]) || (j > p.i && unicode.IsDigit(p.src[j]))) {
+		j++
+	}
+	s := string(p.src[p.i:j])
+	p.i = j
+	return s
+}
+
+func (p *snippetParser) peek(c rune) bool {
+	return p.i < len(p.src) && p.src[p.i] == c
+}
+
+func (p *snippetParser) next(c rune) bool {
+	if p.peek(c) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func isSnippetEscape(c rune) bool {
+	return c == '$' || c == '}' || c == '\\'
+}
@@ -0,0 +1,38 @@
+package text
+
+import "testing"
+
+func TestExpandSnippet(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		snippet string
+		text    string
+		q0, q1  int
+	}{
+		{"Plain", "Println", "Println", 7, 7},
+		{"FinalTabstop", "Println($0)", "Println()", 8, 8},
+		{"Placeholder", "Println(${1:a ...any})$0", "Println(a ...any)", 8, 16},
+		{"FirstByNumber", "f(${2:b}, ${1:a})", "f(b, a)", 5, 6},
+		{"Tabstops", "for $1 := range $2 {\n\t$0\n}", "for  := range  {\n\t\n}", 4, 4},
+		{"Nested", "${1:foo(${2:bar})}", "foo(bar)", 0, 8},
+		{"Mirror", "${1:x} = $1", "x = x", 0, 1},
+		{"MirrorBraces", "${1:ab} ${1} ${2:c} $2", "ab ab c c", 0, 2},
+		{"MirrorBefore", "$1 = ${1:x}", "x = x", 0, 1},
+		{"MirrorBeforeBraces", "f(${2}, ${1:a}, ${2:b})", "f(b, a, b)", 5, 6},
+		{"Choice", "${1|one,two,three|} end", "one end", 0, 3},
+		{"Variable", "$TM_FILENAME ${TM_LINE_NUMBER:1} ${FOO}", " 1 ", 3, 3},
+		{"Escapes", `\$1 \} \\ ${1:a\}b}`, `$1 } \ a}b`, 7, 10},
+		{"LiteralDollar", "cost: $ 5", "cost: $ 5", 9, 9},
+		{"LiteralBrace", "f() {}", "f() {}", 6, 6},
+		{"Transform", "${1/(.*)/${1:/upcase}/}x", "x", 0, 0},
+		{"Unterminated", "f(${1:a", "f(a", 2, 3},
+		{"Unicode", "世界(${1:x})", "世界(x)", 3, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text, q0, q1 := ExpandSnippet(tc.snippet)
+			if text != tc.text || q0 != tc.q0 || q1 != tc.q1 {
+				t.Errorf("ExpandSnippet(%q) = %q, %v, %v; want %q, %v, %v", tc.snippet, text, q0, q1, tc.text, tc.q0, tc.q1)
+			}
+		})
+	}
+}
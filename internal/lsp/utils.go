@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"log"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"sync"
 
 	"9fans.net/acme-lsp/internal/lsp/proxy"
@@ -71,6 +73,249 @@ Kinds:
 
 }
 
+// ServerProvidesRangeFormatting reports whether the server can format a
+// range of a document, and whether it can format several ranges in one
+// textDocument/rangesFormatting request.
+func ServerProvidesRangeFormatting(cap *protocol.ServerCapabilities) (ok, ranges bool) {
+	// The provider is either a boolean or DocumentRangeFormattingOptions.
+	b, err := json.Marshal(cap.DocumentRangeFormattingProvider)
+	if err != nil {
+		return false, false
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return false, false
+	}
+	switch v := v.(type) {
+	case bool:
+		return v, false
+	case map[string]interface{}:
+		ranges, _ := v["rangesSupport"].(bool)
+		return true, ranges
+	}
+	return false, false
+}
+
+// ServerProvidesPrepareRename reports whether the server supports
+// textDocument/prepareRename requests.
+func ServerProvidesPrepareRename(cap *protocol.ServerCapabilities) bool {
+	// The provider is either a boolean or RenameOptions.
+	b, err := json.Marshal(cap.RenameProvider)
+	if err != nil {
+		return false
+	}
+	var opts struct {
+		PrepareProvider bool `json:"prepareProvider"`
+	}
+	if err := json.Unmarshal(b, &opts); err != nil {
+		return false
+	}
+	return opts.PrepareProvider
+}
+
+// ServerProvidesPullDiagnostics reports whether the server answers
+// textDocument/diagnostic requests, and whether it also answers
+// workspace/diagnostic requests.
+func ServerProvidesPullDiagnostics(cap *protocol.ServerCapabilities) (ok, workspace bool) {
+	// The provider is either DiagnosticOptions or DiagnosticRegistrationOptions.
+	b, err := json.Marshal(cap.DiagnosticProvider)
+	if err != nil {
+		return false, false
+	}
+	var opts *struct {
+		WorkspaceDiagnostics bool `json:"workspaceDiagnostics"`
+	}
+	if err := json.Unmarshal(b, &opts); err != nil || opts == nil {
+		return false, false
+	}
+	return true, opts.WorkspaceDiagnostics
+}
+
+// ServerCommands returns the commands the server can execute with
+// workspace/executeCommand requests.
+func ServerCommands(cap *protocol.ServerCapabilities) []string {
+	b, err := json.Marshal(cap.ExecuteCommandProvider)
+	if err != nil {
+		return nil
+	}
+	var opts struct {
+		Commands []string `json:"commands"`
+	}
+	if err := json.Unmarshal(b, &opts); err != nil {
+		return nil
+	}
+	return opts.Commands
+}
+
+// ServerProvidesWorkspaceSymbolResolve reports whether the server
+// answers workspaceSymbol/resolve requests.
+func ServerProvidesWorkspaceSymbolResolve(cap *protocol.ServerCapabilities) bool {
+	// The provider is either a boolean or WorkspaceSymbolOptions.
+	b, err := json.Marshal(cap.WorkspaceSymbolProvider)
+	if err != nil {
+		return false
+	}
+	var opts struct {
+		ResolveProvider bool `json:"resolveProvider"`
+	}
+	if err := json.Unmarshal(b, &opts); err != nil {
+		return false
+	}
+	return opts.ResolveProvider
+}
+
+// FileOperationFilters are the filters of the files a server wants to
+// be notified about when they're created, renamed or deleted.
+type FileOperationFilters struct {
+	filters []fileOperationFilter
+}
+
+type fileOperationFilter struct {
+	scheme  string
+	matches string // "file", "folder" or empty for both
+	pattern *regexp.Regexp
+}
+
+// ServerFileOperationFilters returns the filters of the files the server
+// wants to be sent workspace/didCreateFiles, workspace/didRenameFiles and
+// workspace/didDeleteFiles notifications about. A filter is nil if the
+// server doesn't want the notification at all. Filters with invalid glob
+// patterns are ignored.
+func ServerFileOperationFilters(cap *protocol.ServerCapabilities) (create, rename, delete *FileOperationFilters) {
+	b, err := json.Marshal(cap.Workspace)
+	if err != nil {
+		return nil, nil, nil
+	}
+	type registration struct {
+		Filters []struct {
+			Scheme  string `json:"scheme"`
+			Pattern struct {
+				Glob    string `json:"glob"`
+				Matches string `json:"matches"`
+				Options struct {
+					IgnoreCase bool `json:"ignoreCase"`
+				} `json:"options"`
+			} `json:"pattern"`
+		} `json:"filters"`
+	}
+	var ws struct {
+		FileOperations struct {
+			DidCreate *registration `json:"didCreate"`
+			DidRename *registration `json:"didRename"`
+			DidDelete *registration `json:"didDelete"`
+		} `json:"fileOperations"`
+	}
+	if err := json.Unmarshal(b, &ws); err != nil {
+		return nil, nil, nil
+	}
+	compile := func(r *registration) *FileOperationFilters {
+		if r == nil {
+			return nil
+		}
+		ff := &FileOperationFilters{}
+		for _, f := range r.Filters {
+			re, err := globRegexp(f.Pattern.Glob, f.Pattern.Options.IgnoreCase)
+			if err != nil {
+				continue
+			}
+			ff.filters = append(ff.filters, fileOperationFilter{
+				scheme:  f.Scheme,
+				matches: f.Pattern.Matches,
+				pattern: re,
+			})
+		}
+		return ff
+	}
+	ops := &ws.FileOperations
+	return compile(ops.DidCreate), compile(ops.DidRename), compile(ops.DidDelete)
+}
+
+// Match reports whether the file or folder uri passes any of the
+// filters. Kind is "file" or "folder", or empty if it's unknown, such
+// as for a deleted file, in which case uri may be either.
+func (ff *FileOperationFilters) Match(uri string, kind string) bool {
+	if ff == nil {
+		return false
+	}
+	scheme, _, _ := strings.Cut(uri, ":")
+	path := text.ToPath(protocol.DocumentURI(uri))
+	for _, f := range ff.filters {
+		if f.scheme != "" && f.scheme != scheme {
+			continue
+		}
+		if f.matches != "" && kind != "" && f.matches != kind {
+			continue
+		}
+		if f.pattern.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// globRegexp converts the LSP glob pattern glob to a regular expression
+// matching whole paths. The glob may contain * (any characters but /),
+// ** (any characters), ? (any character but /), {a,b} (a or b) and
+// [a-z] or [!a-z] (character ranges).
+func globRegexp(glob string, ignoreCase bool) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if ignoreCase {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				b.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '{':
+			braces++
+			b.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				b.WriteString(`\}`)
+				break
+			}
+			braces--
+			b.WriteString(")")
+		case ',':
+			if braces == 0 {
+				b.WriteString(",")
+				break
+			}
+			b.WriteString("|")
+		case '[':
+			j := strings.IndexByte(glob[i:], ']')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated character range in glob %q", glob)
+			}
+			r := glob[i+1 : i+j]
+			if strings.HasPrefix(r, "!") {
+				r = "^" + r[1:]
+			}
+			b.WriteString("[" + r + "]")
+			i += j
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if braces != 0 {
+		return nil, fmt.Errorf("unterminated braces in glob %q", glob)
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
 func ServerSupportsIncrementalSync(cap *protocol.ServerCapabilities) bool {
 	switch v := cap.TextDocumentSync.(type) {
 	case float64:
@@ -86,6 +331,22 @@ func ServerSupportsIncrementalSync(cap *protocol.ServerCapabilities) bool {
 	return false
 }
 
+// ComparePositions returns an integer comparing two positions.
+// The result will be 0 if a == b, -1 if a < b, and +1 if a > b.
+func ComparePositions(a, b protocol.Position) int {
+	switch {
+	case a.Line < b.Line:
+		return -1
+	case a.Line > b.Line:
+		return 1
+	case a.Character < b.Character:
+		return -1
+	case a.Character > b.Character:
+		return 1
+	}
+	return 0
+}
+
 func LocationLink(l *protocol.Location, basedir string) string {
 	p := text.ToPath(l.URI)
 	rel, err := filepath.Rel(basedir, p)
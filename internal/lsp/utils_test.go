@@ -1,6 +1,7 @@
 package lsp
 
 import (
+	"encoding/json"
 	"testing"
 
 	"9fans.net/internal/go-lsp/lsp/protocol"
@@ -86,3 +87,155 @@ func TestCompatibleCodeActions(t *testing.T) {
 		})
 	}
 }
+
+func TestServerProvidesRangeFormatting(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		cap        string // JSON encoded server capabilities
+		ok, ranges bool
+	}{
+		{"Missing", `{}`, false, false},
+		{"False", `{"documentRangeFormattingProvider": false}`, false, false},
+		{"True", `{"documentRangeFormattingProvider": true}`, true, false},
+		{"Options", `{"documentRangeFormattingProvider": {}}`, true, false},
+		{"RangesSupport", `{"documentRangeFormattingProvider": {"rangesSupport": true}}`, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cap protocol.ServerCapabilities
+			if err := json.Unmarshal([]byte(tc.cap), &cap); err != nil {
+				t.Fatalf("failed to unmarshal capabilities: %v", err)
+			}
+			ok, ranges := ServerProvidesRangeFormatting(&cap)
+			if ok != tc.ok || ranges != tc.ranges {
+				t.Errorf("got (%v, %v); want (%v, %v)", ok, ranges, tc.ok, tc.ranges)
+			}
+		})
+	}
+}
+
+func TestServerProvidesPullDiagnostics(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		cap           string // JSON encoded server capabilities
+		ok, workspace bool
+	}{
+		{"Missing", `{}`, false, false},
+		{"Options", `{"diagnosticProvider": {"interFileDependencies": true, "workspaceDiagnostics": false}}`, true, false},
+		{"Workspace", `{"diagnosticProvider": {"interFileDependencies": false, "workspaceDiagnostics": true}}`, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cap protocol.ServerCapabilities
+			if err := json.Unmarshal([]byte(tc.cap), &cap); err != nil {
+				t.Fatalf("failed to unmarshal capabilities: %v", err)
+			}
+			ok, workspace := ServerProvidesPullDiagnostics(&cap)
+			if ok != tc.ok || workspace != tc.workspace {
+				t.Errorf("got (%v, %v); want (%v, %v)", ok, workspace, tc.ok, tc.workspace)
+			}
+		})
+	}
+}
+
+func TestServerProvidesWorkspaceSymbolResolve(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cap  string // JSON encoded server capabilities
+		want bool
+	}{
+		{"Missing", `{}`, false},
+		{"Boolean", `{"workspaceSymbolProvider": true}`, false},
+		{"NoResolve", `{"workspaceSymbolProvider": {"workDoneProgress": true}}`, false},
+		{"Resolve", `{"workspaceSymbolProvider": {"resolveProvider": true}}`, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cap protocol.ServerCapabilities
+			if err := json.Unmarshal([]byte(tc.cap), &cap); err != nil {
+				t.Fatalf("failed to unmarshal capabilities: %v", err)
+			}
+			if got := ServerProvidesWorkspaceSymbolResolve(&cap); got != tc.want {
+				t.Errorf("got %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cap  string // JSON encoded server capabilities
+		want []string
+	}{
+		{"Missing", `{}`, nil},
+		{"Empty", `{"executeCommandProvider": {}}`, nil},
+		{"Commands", `{"executeCommandProvider": {"commands": ["a", "b"]}}`, []string{"a", "b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cap protocol.ServerCapabilities
+			if err := json.Unmarshal([]byte(tc.cap), &cap); err != nil {
+				t.Fatalf("failed to unmarshal capabilities: %v", err)
+			}
+			if got := ServerCommands(&cap); !cmp.Equal(got, tc.want) {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServerFileOperationFilters(t *testing.T) {
+	for _, tc := range []struct {
+		name                   string
+		cap                    string // JSON encoded server capabilities
+		create, rename, delete bool
+	}{
+		{"Missing", `{}`, false, false, false},
+		{"NoFileOperations", `{"workspace": {}}`, false, false, false},
+		{"Rename", `{"workspace": {"fileOperations": {"didRename": {"filters": [{"pattern": {"glob": "**/*.rs"}}]}}}}`, false, true, false},
+		{"All", `{"workspace": {"fileOperations": {"didCreate": {"filters": []}, "didRename": {"filters": []}, "didDelete": {"filters": []}}}}`, true, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cap protocol.ServerCapabilities
+			if err := json.Unmarshal([]byte(tc.cap), &cap); err != nil {
+				t.Fatalf("failed to unmarshal capabilities: %v", err)
+			}
+			create, rename, delete := ServerFileOperationFilters(&cap)
+			if (create != nil) != tc.create || (rename != nil) != tc.rename || (delete != nil) != tc.delete {
+				t.Errorf("registered (%v, %v, %v); want (%v, %v, %v)", create != nil, rename != nil, delete != nil, tc.create, tc.rename, tc.delete)
+			}
+		})
+	}
+}
+
+func TestFileOperationFiltersMatch(t *testing.T) {
+	const cap = `{"workspace": {"fileOperations": {"didDelete": {"filters": [
+		{"scheme": "file", "pattern": {"glob": "**/*.{rs,toml}"}},
+		{"scheme": "file", "pattern": {"glob": "**", "matches": "folder"}},
+		{"pattern": {"glob": "/src/[!x]?.GO", "options": {"ignoreCase": true}}}
+	]}}}}`
+	var c protocol.ServerCapabilities
+	if err := json.Unmarshal([]byte(cap), &c); err != nil {
+		t.Fatalf("failed to unmarshal capabilities: %v", err)
+	}
+	_, _, ff := ServerFileOperationFilters(&c)
+	for _, tc := range []struct {
+		uri, kind string
+		want      bool
+	}{
+		{"file:///a/b/main.rs", "file", true},
+		{"file:///a/Cargo.toml", "", true},
+		{"file:///a/main.go", "file", false},
+		{"file:///a/util", "folder", true},
+		{"file:///a/util", "", true},
+		{"file:///src/ab.go", "file", true},
+		{"file:///src/xb.go", "file", false},
+		{"file:///src/sub/ab.go", "file", false},
+		{"jdt://contents/Object.class", "file", false},
+	} {
+		if got := ff.Match(tc.uri, tc.kind); got != tc.want {
+			t.Errorf("Match(%q, %q) is %v; want %v", tc.uri, tc.kind, got, tc.want)
+		}
+	}
+	var none *FileOperationFilters
+	if none.Match("file:///a/main.rs", "file") {
+		t.Errorf("nil filters match")
+	}
+}